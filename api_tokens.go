@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cobra"
+)
+
+// scopes an api token can be given
+const (
+	scopePostsWrite   = "posts:write"
+	scopeUploadsWrite = "uploads:write"
+	scopeRead         = "read"
+)
+
+var apiScopes = []string{scopePostsWrite, scopeUploadsWrite, scopeRead}
+
+// apiTokenPrefix marks api tokens, so they can't be mistaken for a
+// pocketbase auth token and are easy to spot when leaked
+const apiTokenPrefix = "feed_"
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIToken stores a new token and returns it in plain text, which is
+// the only time it's available. a zero expires never expires
+func (app *App) createAPIToken(name string, scopes []string, expires time.Time) (string, *core.Record, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(apiScopes, scope) {
+			return "", nil, fmt.Errorf("unknown scope %q, use %s", scope, strings.Join(apiScopes, ", "))
+		}
+	}
+
+	collection, err := app.pb.FindCollectionByNameOrId("api_tokens")
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := apiTokenPrefix + security.RandomString(40)
+
+	record := core.NewRecord(collection)
+	record.Set("name", name)
+	record.Set("token_hash", hashAPIToken(token))
+	record.Set("prefix", token[:len(apiTokenPrefix)+6])
+	record.Set("scopes", scopes)
+	if !expires.IsZero() {
+		record.Set("expires", expires)
+	}
+
+	if err := app.pb.Save(record); err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// requireScope authenticates /api/markdown requests with an api token
+// carrying scope. superusers are let through, so the admin ui's token and
+// `feed login` keep working
+func (app *App) requireScope(scope string) func(*core.RequestEvent) error {
+	return func(re *core.RequestEvent) error {
+		if re.HasSuperuserAuth() {
+			return re.Next()
+		}
+
+		token := strings.TrimPrefix(re.Request.Header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(token, apiTokenPrefix) {
+			return re.UnauthorizedError("An API token is required", nil)
+		}
+
+		record, err := app.pb.FindFirstRecordByData("api_tokens", "token_hash", hashAPIToken(token))
+		if err != nil {
+			return re.UnauthorizedError("Invalid API token", nil)
+		}
+
+		expires := record.GetDateTime("expires")
+		if !expires.IsZero() && expires.Time().Before(time.Now()) {
+			return re.UnauthorizedError("API token has expired", nil)
+		}
+
+		if !slices.Contains(record.GetStringSlice("scopes"), scope) {
+			return re.ForbiddenError(fmt.Sprintf("API token doesn't have the %s scope", scope), nil)
+		}
+
+		// last_used is bookkeeping, so it's written directly instead of
+		// going through a save and its hooks
+		_, err = app.pb.DB().Update(
+			"api_tokens",
+			dbx.Params{"last_used": types.NowDateTime().String()},
+			dbx.HashExp{"id": record.Id},
+		).Execute()
+		if err != nil {
+			app.pb.Logger().Error("Failed to update token last_used", "token_id", record.Id, "error", err)
+		}
+
+		return re.Next()
+	}
+}
+
+// createAPITokenRoute mints a token for the superuser calling it, used by
+// `feed login`
+func (app *App) createAPITokenRoute(re *core.RequestEvent) error {
+	var data struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		Days   int      `json:"days"`
+	}
+	if err := re.BindBody(&data); err != nil {
+		return re.BadRequestError("Invalid request body", err)
+	}
+
+	if data.Name == "" {
+		data.Name = "feed cli"
+	}
+	if len(data.Scopes) == 0 {
+		data.Scopes = apiScopes
+	}
+
+	var expires time.Time
+	if data.Days > 0 {
+		expires = time.Now().AddDate(0, 0, data.Days)
+	}
+
+	token, record, err := app.createAPIToken(data.Name, data.Scopes, expires)
+	if err != nil {
+		return re.BadRequestError("Failed to create token", err)
+	}
+
+	return re.JSON(http.StatusOK, map[string]any{
+		"token":   token,
+		"id":      record.Id,
+		"name":    record.GetString("name"),
+		"scopes":  record.GetStringSlice("scopes"),
+		"expires": record.GetString("expires"),
+	})
+}
+
+// tokenCommand manages api tokens from the server, e.g. for ci
+func (app *App) tokenCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "token",
+		Short: "Manage API tokens for the markdown API",
+	}
+
+	var scopes []string
+	var days int
+	create := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a token and print it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var expires time.Time
+			if days > 0 {
+				expires = time.Now().AddDate(0, 0, days)
+			}
+
+			token, record, err := app.createAPIToken(args[0], scopes, expires)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Created token %s (%s)\n", record.Id, strings.Join(record.GetStringSlice("scopes"), ", "))
+			fmt.Println(token)
+			return nil
+		},
+	}
+	create.Flags().StringSliceVar(&scopes, "scopes", apiScopes, "scopes to grant")
+	create.Flags().IntVar(&days, "days", 0, "days until the token expires, 0 for never")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List tokens",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tokens, err := app.pb.FindRecordsByFilter("api_tokens", "", "created", 0, 0)
+			if err != nil {
+				return err
+			}
+
+			for _, token := range tokens {
+				expires := token.GetString("expires")
+				if expires == "" {
+					expires = "never"
+				}
+				lastUsed := token.GetString("last_used")
+				if lastUsed == "" {
+					lastUsed = "never"
+				}
+				fmt.Printf("%s  %s…  %s  [%s]  expires %s, last used %s\n",
+					token.Id,
+					token.GetString("prefix"),
+					token.GetString("name"),
+					strings.Join(token.GetStringSlice("scopes"), ", "),
+					expires,
+					lastUsed,
+				)
+			}
+			return nil
+		},
+	}
+
+	revoke := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Delete a token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := app.pb.FindRecordById("api_tokens", args[0])
+			if err != nil {
+				return fmt.Errorf("token %q not found", args[0])
+			}
+			if err := app.pb.Delete(token); err != nil {
+				return err
+			}
+
+			fmt.Printf("Revoked token %s\n", token.Id)
+			return nil
+		},
+	}
+
+	command.AddCommand(create, list, revoke)
+	return command
+}
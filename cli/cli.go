@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -26,16 +33,76 @@ const (
 )
 
 type PostResponse struct {
-	Post struct {
+	Created bool `json:"created"`
+	Post    struct {
 		ID        string `json:"id"`
 		Title     string `json:"title"`
 		Slug      string `json:"slug"`
 		Permalink string `json:"permalink"`
 		Created   string `json:"created"`
 	} `json:"post"`
+	Message  string  `json:"message"`
+	Warnings []Issue `json:"warnings"`
+	Errors   []Issue `json:"errors"`
+}
+
+// DryRunResponse is what the server would do with a post
+type DryRunResponse struct {
+	Plan struct {
+		Slug           string         `json:"slug"`
+		Permalink      string         `json:"permalink"`
+		NewTags        []string       `json:"new_tags"`
+		NewCollections []string       `json:"new_collections"`
+		Contexts       []string       `json:"contexts"`
+		Chapters       []ChapterNode  `json:"chapters"`
+		ContentHTML    string         `json:"content_html"`
+		Crossposts     []CrosspostJob `json:"crossposts"`
+	} `json:"plan"`
+	Message  string  `json:"message"`
+	Warnings []Issue `json:"warnings"`
+	Errors   []Issue `json:"errors"`
+}
+
+type ChapterNode struct {
+	Title    string        `json:"title"`
+	Level    int           `json:"level"`
+	Children []ChapterNode `json:"children"`
+}
+
+type CrosspostJob struct {
+	Platform string `json:"platform"`
+	Type     string `json:"type"`
+	Account  string `json:"account"`
+}
+
+// HistoryResponse lists a post's revisions, oldest first
+type HistoryResponse struct {
+	Post struct {
+		ID    string `json:"id"`
+		Slug  string `json:"slug"`
+		Title string `json:"title"`
+	} `json:"post"`
+	Revisions []struct {
+		ID      string `json:"id"`
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		Created string `json:"created"`
+	} `json:"revisions"`
+}
+
+// Issue is a frontmatter problem reported by the server
+type Issue struct {
+	Field   string `json:"field"`
 	Message string `json:"message"`
 }
 
+// ErrorResponse is the body of a rejected post
+type ErrorResponse struct {
+	Message  string  `json:"message"`
+	Warnings []Issue `json:"warnings"`
+	Errors   []Issue `json:"errors"`
+}
+
 type UploadResponse struct {
 	ID          string `json:"id"`
 	File        string `json:"file"`
@@ -45,22 +112,38 @@ type UploadResponse struct {
 
 // Frontmatter struct for parsing YAML
 type Frontmatter struct {
+	ID                 string   `yaml:"id"`
+	Slug               string   `yaml:"slug"`
 	Title              string   `yaml:"title"`
 	Subtitle           string   `yaml:"subtitle"`
 	Tags               []string `yaml:"tags"`
 	Contexts           []string `yaml:"contexts"`
 	Collections        []string `yaml:"collections"`
 	IsVisible          bool     `yaml:"is_visible"`
+	PublishAt          string   `yaml:"publish_at"`
+	UnpublishAt        string   `yaml:"unpublish_at"`
 	FeaturedImage      string   `yaml:"featured_image"`
+	FeaturedImages     []string `yaml:"featured_images"`
 	CrosspostInstagram bool     `yaml:"crosspost_instagram"`
 	CrosspostThreads   bool     `yaml:"crosspost_threads"`
 	Summary            string   `yaml:"summary"`
+
+	// raw keeps every key from the file, including ones this struct doesn't
+	// know about, so the server can warn about them
+	raw yaml.MapSlice
 }
 
-var (
-	appURL = "http://localhost:8090" // default dev fb
-	token  = ""
-)
+// appURL is the server used until `feed login` saves one, set at build time
+var appURL = "http://localhost:8090" // default dev fb
+
+// token is the api token from the config file or FEED_TOKEN
+var token = ""
+
+// Config is what `feed login` saves
+type Config struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
 
 func main() {
 	if len(os.Args) < 2 {
@@ -68,32 +151,141 @@ func main() {
 		os.Exit(1)
 	}
 
-	if token == "" {
-		fmt.Printf("%sError: SUPERUSER_TOKEN not set at build time%s\n", ColorRed, ColorReset)
-		fmt.Printf("%sUse: make build-cli and ensure .env file exists%s\n", ColorYellow, ColorReset)
-		os.Exit(1)
+	if err := loadConfig(); err != nil {
+		fmt.Printf("%sWarning: %v%s\n", ColorYellow, err, ColorReset)
+	}
+
+	args, dryRun := extractFlag(os.Args[1:], "--dry-run")
+	args, pull := extractFlag(args, "--pull")
+	args, purge := extractFlag(args, "--purge")
+
+	switch args[0] {
+	case "login", "logout", "version", "--version", "-v", "help", "--help", "-h":
+	default:
+		if token == "" {
+			fmt.Printf("%sError: Not logged in%s\n", ColorRed, ColorReset)
+			fmt.Printf("%sRun 'feed login' or set FEED_TOKEN%s\n", ColorYellow, ColorReset)
+			os.Exit(1)
+		}
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
+	case "login":
+		serverURL := appURL
+		if len(args) > 1 {
+			serverURL = strings.TrimRight(args[1], "/")
+		}
+		err := login(serverURL)
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
+	case "logout":
+		err := logout()
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
 	case "post":
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			fmt.Printf("%sError: Please specify a markdown file%s\n", ColorRed, ColorReset)
 			printUsage()
 			os.Exit(1)
 		}
-		err := postMarkdown(appURL, token, os.Args[2])
+		err := postMarkdown(appURL, token, args[1], dryRun)
 		if err != nil {
 			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
 			os.Exit(1)
 		}
 
 	case "update":
-		if len(os.Args) < 4 {
+		if len(args) < 3 {
 			fmt.Printf("%sError: Please specify post ID and markdown file%s\n", ColorRed, ColorReset)
 			printUsage()
 			os.Exit(1)
 		}
-		err := updateMarkdown(appURL, token, os.Args[2], os.Args[3])
+		err := updateMarkdown(appURL, token, args[1], args[2], dryRun)
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
+	case "sync":
+		if len(args) < 2 {
+			fmt.Printf("%sError: Please specify a directory%s\n", ColorRed, ColorReset)
+			printUsage()
+			os.Exit(1)
+		}
+		err := syncDir(appURL, token, args[1], pull, dryRun)
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
+	case "delete":
+		if len(args) < 2 {
+			fmt.Printf("%sError: Please specify a post slug or ID%s\n", ColorRed, ColorReset)
+			printUsage()
+			os.Exit(1)
+		}
+		err := deletePost(appURL, token, args[1], purge)
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
+	case "unpublish":
+		if len(args) < 2 {
+			fmt.Printf("%sError: Please specify a post slug or ID%s\n", ColorRed, ColorReset)
+			printUsage()
+			os.Exit(1)
+		}
+		err := unpublishPost(appURL, token, args[1])
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
+	case "history":
+		if len(args) < 2 {
+			fmt.Printf("%sError: Please specify a post slug or ID%s\n", ColorRed, ColorReset)
+			printUsage()
+			os.Exit(1)
+		}
+		err := showHistory(appURL, token, args[1])
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
+	case "diff":
+		if len(args) < 2 {
+			fmt.Printf("%sError: Please specify a post slug or ID%s\n", ColorRed, ColorReset)
+			printUsage()
+			os.Exit(1)
+		}
+		var from, to string
+		if len(args) > 2 {
+			from = args[2]
+		}
+		if len(args) > 3 {
+			to = args[3]
+		}
+		err := showDiff(appURL, token, args[1], from, to)
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
+			os.Exit(1)
+		}
+
+	case "restore":
+		if len(args) < 2 {
+			fmt.Printf("%sError: Please specify a revision ID%s\n", ColorRed, ColorReset)
+			printUsage()
+			os.Exit(1)
+		}
+		err := restoreRevision(appURL, token, args[1], dryRun)
 		if err != nil {
 			fmt.Printf("%sError: %v%s\n", ColorRed, err, ColorReset)
 			os.Exit(1)
@@ -107,7 +299,7 @@ func main() {
 		printUsage()
 
 	default:
-		fmt.Printf("%sError: Unknown command '%s'%s\n", ColorRed, os.Args[1], ColorReset)
+		fmt.Printf("%sError: Unknown command '%s'%s\n", ColorRed, args[0], ColorReset)
 		printUsage()
 		os.Exit(1)
 	}
@@ -119,22 +311,234 @@ func printUsage() {
 	fmt.Println("Usage: feed <command> [options]")
 	fmt.Println("")
 	fmt.Println("Commands:")
-	fmt.Printf("  %spost%s <file.md>           Create a new post from markdown file\n", ColorBlue, ColorReset)
+	fmt.Printf("  %slogin%s [url]              Sign in as a superuser and save an API token\n", ColorBlue, ColorReset)
+	fmt.Printf("  %slogout%s                   Forget the saved API token\n", ColorBlue, ColorReset)
+	fmt.Printf("  %spost%s <file.md>           Create or update a post from markdown file\n", ColorBlue, ColorReset)
 	fmt.Printf("  %supdate%s <post_id> <file>  Update existing post\n", ColorBlue, ColorReset)
+	fmt.Printf("  %ssync%s <dir>               Push new and changed posts in a directory\n", ColorBlue, ColorReset)
+	fmt.Printf("  %sdelete%s <slug|id>         Move a post to the trash\n", ColorBlue, ColorReset)
+	fmt.Printf("  %sunpublish%s <slug|id>      Hide a post without deleting it\n", ColorBlue, ColorReset)
+	fmt.Printf("  %shistory%s <slug|id>        List a post's revisions\n", ColorBlue, ColorReset)
+	fmt.Printf("  %sdiff%s <slug|id> [from] [to]\n", ColorBlue, ColorReset)
+	fmt.Println("                          Diff two revisions, the latest two by default")
+	fmt.Printf("  %srestore%s <revision_id>    Restore a post to an earlier revision\n", ColorBlue, ColorReset)
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Printf("  %s--dry-run%s               Show what post/update/restore/sync would do without saving\n", ColorBlue, ColorReset)
+	fmt.Printf("  %s--pull%s                  With sync, download posts edited on the server\n", ColorBlue, ColorReset)
+	fmt.Printf("  %s--purge%s                 With delete, delete for good instead of trashing\n", ColorBlue, ColorReset)
 	fmt.Printf("  %sversion%s                 Show version information\n", ColorBlue, ColorReset)
 	fmt.Printf("  %shelp%s                     Show this help\n", ColorBlue, ColorReset)
 	fmt.Println("")
 	fmt.Println("Examples:")
 	fmt.Printf("  %sfeed post quick-blog.md%s\n", ColorGreen, ColorReset)
 	fmt.Printf("  %sfeed update abc123 updated-blog.md%s\n", ColorGreen, ColorReset)
+	fmt.Printf("  %sfeed post --dry-run quick-blog.md%s\n", ColorGreen, ColorReset)
+	fmt.Printf("  %sfeed sync --pull ./posts%s\n", ColorGreen, ColorReset)
+	fmt.Printf("  %sfeed diff quick-blog 1 3%s\n", ColorGreen, ColorReset)
 	fmt.Println("")
-	fmt.Printf("%sConfig is embedded at build time from .env file%s\n", ColorYellow, ColorReset)
+	fmt.Printf("%sFEED_URL and FEED_TOKEN override the config saved by login%s\n", ColorYellow, ColorReset)
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "feed", "config.json"), nil
+}
+
+// loadConfig sets appURL and token from the config file, with FEED_URL and
+// FEED_TOKEN taking precedence
+func loadConfig() error {
+	defer func() {
+		if envURL := os.Getenv("FEED_URL"); envURL != "" {
+			appURL = strings.TrimRight(envURL, "/")
+		}
+		if envToken := os.Getenv("FEED_TOKEN"); envToken != "" {
+			token = envToken
+		}
+	}()
+
+	path, err := configPath()
+	if err != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if config.URL != "" {
+		appURL = config.URL
+	}
+	token = config.Token
+	return nil
+}
+
+func saveConfig(config Config) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// login signs in as a superuser and trades the session for an api token,
+// so the password and the superuser token are never stored
+func login(serverURL string) error {
+	fmt.Printf("%sLogging in to %s%s\n", ColorBlue, serverURL, ColorReset)
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Email: ")
+	email, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read email: %v", err)
+	}
+
+	fmt.Print("Password: ")
+	password, err := readPassword(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read password: %v", err)
+	}
+
+	credentials, err := json.Marshal(map[string]string{
+		"identity": strings.TrimSpace(email),
+		"password": password,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(serverURL+"/api/collections/_superusers/auth-with-password", "application/json", bytes.NewReader(credentials))
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		return serverError(resp.StatusCode, body)
+	}
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &auth); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	name := "feed cli"
+	if hostname, err := os.Hostname(); err == nil {
+		name += " on " + hostname
+	}
+	request, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return err
+	}
+
+	body, err = apiRequest("POST", serverURL+"/api/markdown/tokens", auth.Token, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+
+	var created struct {
+		Token  string   `json:"token"`
+		ID     string   `json:"id"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	path, err := saveConfig(Config{URL: serverURL, Token: created.Token})
+	if err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+
+	fmt.Printf("%sLogged in!%s\n", ColorGreen, ColorReset)
+	fmt.Printf("%sToken %s (%s) saved to %s%s\n", ColorBlue, created.ID, strings.Join(created.Scopes, ", "), path, ColorReset)
+	return nil
+}
+
+// readPassword reads a line with terminal echo turned off where stty is
+// available
+func readPassword(reader *bufio.Reader) (string, error) {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Println()
+		}()
+	}
+
+	password, err := reader.ReadString('\n')
+	return strings.TrimRight(password, "\r\n"), err
+}
+
+// logout removes the saved config. the token stays valid on the server
+// until it's revoked there
+func logout() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	fmt.Printf("%sLogged out%s\n", ColorGreen, ColorReset)
+	fmt.Printf("%sRevoke the token on the server with 'token revoke <id>' if it's no longer needed%s\n", ColorBlue, ColorReset)
+	return nil
+}
+
+// extractFlag removes flag from args, reporting whether it was there
+func extractFlag(args []string, flag string) ([]string, bool) {
+	var rest []string
+	found := false
+	for _, arg := range args {
+		if arg == flag {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) == 0 {
+		rest = []string{"help"}
+	}
+	return rest, found
 }
 
 func parseFrontmatter(content string) (*Frontmatter, string, error) {
 	lines := strings.Split(content, "\n")
 
-	if len(lines) < 3 || lines[0] != "---" {
+	// files saved with crlf line endings keep a \r on each line
+	if len(lines) < 3 || strings.TrimSuffix(lines[0], "\r") != "---" {
 		return nil, content, nil
 	}
 
@@ -142,7 +546,7 @@ func parseFrontmatter(content string) (*Frontmatter, string, error) {
 	var contentStart int
 
 	for i := 1; i < len(lines); i++ {
-		if lines[i] == "---" {
+		if strings.TrimSuffix(lines[i], "\r") == "---" {
 			contentStart = i + 1
 			break
 		}
@@ -160,12 +564,49 @@ func parseFrontmatter(content string) (*Frontmatter, string, error) {
 	if err := yaml.Unmarshal([]byte(frontmatterContent), &frontmatter); err != nil {
 		return nil, "", fmt.Errorf("failed to parse frontmatter: %v", err)
 	}
+	if err := yaml.Unmarshal([]byte(frontmatterContent), &frontmatter.raw); err != nil {
+		return nil, "", fmt.Errorf("failed to parse frontmatter: %v", err)
+	}
 
 	return &frontmatter, markdownContent, nil
 }
 
+// writeFrontmatterID adds an id key to the top of the file's frontmatter,
+// leaving the rest of the file as it was
+func writeFrontmatterID(filename, content, id string) error {
+	newline := "\n"
+	if strings.HasPrefix(content, "---\r\n") {
+		newline = "\r\n"
+	}
+
+	rest, ok := strings.CutPrefix(content, "---"+newline)
+	if !ok {
+		return fmt.Errorf("no frontmatter")
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, []byte("---"+newline+"id: "+id+newline+rest), info.Mode())
+}
+
+// reconstructContent writes the frontmatter back in its original order, with
+// the featured images swapped for their upload ids
 func reconstructContent(frontmatter *Frontmatter, markdownContent string) (string, error) {
-	frontmatterBytes, err := yaml.Marshal(frontmatter)
+	raw := make(yaml.MapSlice, len(frontmatter.raw))
+	copy(raw, frontmatter.raw)
+	for i, item := range raw {
+		switch item.Key {
+		case "featured_image":
+			raw[i].Value = frontmatter.FeaturedImage
+		case "featured_images":
+			raw[i].Value = frontmatter.FeaturedImages
+		}
+	}
+
+	frontmatterBytes, err := yaml.Marshal(raw)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal frontmatter: %v", err)
 	}
@@ -173,32 +614,53 @@ func reconstructContent(frontmatter *Frontmatter, markdownContent string) (strin
 	return fmt.Sprintf("---\n%s---\n%s", string(frontmatterBytes), markdownContent), nil
 }
 
-func processFeaturedImage(frontmatter *Frontmatter, appURL, token string) error {
-	if frontmatter.FeaturedImage == "" {
-		return nil
+func processFeaturedImage(frontmatter *Frontmatter, appURL, token string, dryRun bool, assets *assetCache) error {
+	if frontmatter.FeaturedImage != "" {
+		id, err := uploadFeaturedImage(frontmatter.FeaturedImage, appURL, token, dryRun, assets)
+		if err != nil {
+			return err
+		}
+		frontmatter.FeaturedImage = id
+	}
+
+	for i, image := range frontmatter.FeaturedImages {
+		id, err := uploadFeaturedImage(image, appURL, token, dryRun, assets)
+		if err != nil {
+			return err
+		}
+		frontmatter.FeaturedImages[i] = id
 	}
 
-	if strings.Contains(frontmatter.FeaturedImage, "/api/files/") || (len(frontmatter.FeaturedImage) == 15 && !strings.Contains(frontmatter.FeaturedImage, "/")) {
+	return nil
+}
+
+// uploadFeaturedImage uploads a featured image and returns its upload id.
+// images that are already uploads, and dry runs, keep the value they had
+func uploadFeaturedImage(image, appURL, token string, dryRun bool, assets *assetCache) (string, error) {
+	if strings.Contains(image, "/api/files/") || (len(image) == 15 && !strings.Contains(image, "/")) {
 		fmt.Printf("%sSkipping featured image (already processed)%s\n", ColorYellow, ColorReset)
-		return nil
+		return image, nil
 	}
 
-	fmt.Printf("%sProcessing featured image: %s%s\n", ColorBlue, frontmatter.FeaturedImage, ColorReset)
+	if dryRun {
+		fmt.Printf("%sWould upload featured image: %s%s\n", ColorYellow, image, ColorReset)
+		return image, nil
+	}
+
+	fmt.Printf("%sProcessing featured image: %s%s\n", ColorBlue, image, ColorReset)
 
 	// Upload the featured image
-	uploadResp, err := uploadAsset(frontmatter.FeaturedImage, "Featured image", "", appURL, token)
+	uploadResp, err := assets.upload(image, "Featured image", "", appURL, token)
 	if err != nil {
-		return fmt.Errorf("failed to upload featured image: %v", err)
+		return "", fmt.Errorf("failed to upload featured image: %v", err)
 	}
 
 	// Replace with upload ID (you can also use the full PocketBase URL if preferred)
-	frontmatter.FeaturedImage = uploadResp.ID
 	fmt.Printf("%sFeatured image uploaded: %s%s\n", ColorGreen, uploadResp.ID, ColorReset)
-
-	return nil
+	return uploadResp.ID, nil
 }
 
-func processAssets(content string, appURL, token string) (string, error) {
+func processAssets(content string, appURL, token string, dryRun bool, assets *assetCache) (string, error) {
 	// regex to match markdown image syntax: ![alt](url "title")
 	imageRegex := regexp.MustCompile(`!\[([^\]]*)\]\(([^)]+?)(?:\s+"([^"]*)")?\)`)
 
@@ -237,10 +699,15 @@ func processAssets(content string, appURL, token string) (string, error) {
 			continue
 		}
 
+		if dryRun {
+			fmt.Printf("%s  [%d/%d] Would upload: %s%s\n", ColorYellow, i+1, len(matches), originalURL, ColorReset)
+			continue
+		}
+
 		fmt.Printf("%s  [%d/%d] Processing: %s%s\n", ColorBlue, i+1, len(matches), originalURL, ColorReset)
 
 		// upload the asset
-		uploadResp, err := uploadAsset(originalURL, altText, title, appURL, token)
+		uploadResp, err := assets.upload(originalURL, altText, title, appURL, token)
 		if err != nil {
 			fmt.Printf("%s    Failed: %v%s\n", ColorRed, err, ColorReset)
 			continue
@@ -265,6 +732,99 @@ func processAssets(content string, appURL, token string) (string, error) {
 	return updatedContent, nil
 }
 
+// assetCache lets post and sync reuse uploads from earlier runs. keys are
+// asset paths as written in the markdown, local ones being relative to dir
+type assetCache struct {
+	dir     string
+	uploads map[string]SyncUpload
+}
+
+// fileAssetCache is the upload cache sync keeps for a file in the manifest
+// of the directory it syncs, so posting the file again only uploads assets
+// that changed. save writes the uploads back, leaving the rest of the
+// manifest alone
+func fileAssetCache(filename string) (assets *assetCache, save func() error, err error) {
+	filename, err = filepath.Abs(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	root := syncRoot(filename)
+	manifest, err := loadSyncManifest(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// keyed the way sync walks the root, so both share the entry
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	rel = filepath.ToSlash(rel)
+	entry := manifest.Files[rel]
+	if entry == nil {
+		entry = &SyncEntry{}
+	}
+	if entry.Uploads == nil {
+		entry.Uploads = map[string]SyncUpload{}
+	}
+
+	// local assets are written relative to the markdown file
+	assets = &assetCache{dir: filepath.Dir(filename), uploads: entry.Uploads}
+	save = func() error {
+		if len(entry.Uploads) == 0 {
+			return nil
+		}
+		manifest.Files[rel] = entry
+		return manifest.save(root)
+	}
+	return assets, save, nil
+}
+
+// syncRoot is the nearest directory above filename with a sync manifest,
+// or the file's own directory when there is none. filename is absolute
+func syncRoot(filename string) string {
+	for dir := filepath.Dir(filename); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, syncManifestName)); err == nil {
+			return dir
+		}
+		if dir == filepath.Dir(dir) {
+			return filepath.Dir(filename)
+		}
+	}
+}
+
+// upload is uploadAsset through the cache. local files are uploaded again
+// when their content changed, remote ones only once. a nil cache always uploads
+func (c *assetCache) upload(assetURL, altText, title, appURL, token string) (*UploadResponse, error) {
+	if c == nil {
+		return uploadAsset(assetURL, altText, title, appURL, token)
+	}
+
+	path := assetURL
+	hash := ""
+	if !strings.HasPrefix(assetURL, "http://") && !strings.HasPrefix(assetURL, "https://") {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local file: %v", err)
+		}
+		hash = contentHash(data)
+	}
+
+	if cached, ok := c.uploads[assetURL]; ok && cached.Hash == hash {
+		return &UploadResponse{ID: cached.ID, File: cached.File}, nil
+	}
+
+	uploadResp, err := uploadAsset(path, altText, title, appURL, token)
+	if err != nil {
+		return nil, err
+	}
+	c.uploads[assetURL] = SyncUpload{ID: uploadResp.ID, File: uploadResp.File, Hash: hash}
+	return uploadResp, nil
+}
+
 func uploadAsset(assetURL, altText, title, appURL, token string) (*UploadResponse, error) {
 	var fileData []byte
 	var filename string
@@ -336,7 +896,7 @@ func uploadAsset(assetURL, altText, title, appURL, token string) (*UploadRespons
 	writer.Close()
 
 	// Create request
-	url := appURL + "/api/collections/uploads/records"
+	url := appURL + "/api/markdown/uploads"
 	req, err := http.NewRequest("POST", url, &buffer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
@@ -430,86 +990,165 @@ func downloadRemoteFile(url string) ([]byte, string, error) {
 	return data, filename, nil
 }
 
-func postMarkdown(appURL, token, filename string) error {
+// preparePost reads a markdown file and uploads its featured image and
+// assets, returning the file as read, its frontmatter and the content to send
+func preparePost(appURL, token, filename string, dryRun bool, assets *assetCache) (string, *Frontmatter, string, error) {
 	// Check if file exists
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return fmt.Errorf("file '%s' not found", filename)
+		return "", nil, "", fmt.Errorf("file '%s' not found", filename)
 	}
 
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("failed to read file %s: %v", filename, err)
+		return "", nil, "", fmt.Errorf("failed to read file %s: %v", filename, err)
 	}
 
-	fmt.Printf("%sPosting %s to your feed...%s\n", ColorBlue, filename, ColorReset)
-
 	content := string(data)
 
 	// Parse frontmatter
 	frontmatter, markdownContent, err := parseFrontmatter(content)
 	if err != nil {
-		return fmt.Errorf("failed to parse frontmatter: %v", err)
+		return "", nil, "", fmt.Errorf("failed to parse frontmatter: %v", err)
 	}
 
 	// Process featured image if present
 	if frontmatter != nil {
-		if err := processFeaturedImage(frontmatter, appURL, token); err != nil {
-			return fmt.Errorf("failed to process featured image: %v", err)
+		if err := processFeaturedImage(frontmatter, appURL, token, dryRun, assets); err != nil {
+			return "", nil, "", fmt.Errorf("failed to process featured image: %v", err)
 		}
 	}
 
 	// Process assets in markdown content
-	processedMarkdown, err := processAssets(markdownContent, appURL, token)
+	processedMarkdown, err := processAssets(markdownContent, appURL, token, dryRun, assets)
 	if err != nil {
-		return fmt.Errorf("failed to process assets: %v", err)
+		return "", nil, "", fmt.Errorf("failed to process assets: %v", err)
 	}
 
 	// Reconstruct content with updated frontmatter
-	var processedContent string
-	if frontmatter != nil {
-		processedContent, err = reconstructContent(frontmatter, processedMarkdown)
-		if err != nil {
-			return fmt.Errorf("failed to reconstruct content: %v", err)
-		}
-	} else {
-		processedContent = processedMarkdown
+	if frontmatter == nil {
+		return content, nil, processedMarkdown, nil
 	}
 
-	url := appURL + "/api/markdown/posts"
-	req, err := http.NewRequest("POST", url, strings.NewReader(processedContent))
+	processedContent, err := reconstructContent(frontmatter, processedMarkdown)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return "", nil, "", fmt.Errorf("failed to reconstruct content: %v", err)
 	}
+	return content, frontmatter, processedContent, nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "text/plain")
+func postMarkdown(appURL, token, filename string, dryRun bool) error {
+	fmt.Printf("%sPosting %s to your feed...%s\n", ColorBlue, filename, ColorReset)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	assets, saveAssets, err := fileAssetCache(filename)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	content, frontmatter, processedContent, err := preparePost(appURL, token, filename, dryRun, assets)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return err
+	}
+	if !dryRun {
+		if err := saveAssets(); err != nil {
+			fmt.Printf("%sWarning: failed to save the upload cache: %v%s\n", ColorYellow, err, ColorReset)
+		}
 	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("server error (%d): %s", resp.StatusCode, string(body))
+	// the server matches the post by the frontmatter id or slug, so reposting
+	// the same file updates it
+	url := appURL + "/api/markdown/posts"
+	if dryRun {
+		url += "?dry_run=1"
+	}
+	body, err := apiRequest("PUT", url, token, "text/plain", strings.NewReader(processedContent))
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return printDryRun(body)
 	}
 
 	var postResp PostResponse
 	err = json.Unmarshal(body, &postResp)
 	if err != nil {
 		// if we can't parse the response, just show success
+		fmt.Printf("%sPost saved successfully!%s\n", ColorGreen, ColorReset)
+		fmt.Printf("%sServer response: %s%s\n", ColorBlue, string(body), ColorReset)
+		return nil
+	}
+
+	if postResp.Created {
 		fmt.Printf("%sPost created successfully!%s\n", ColorGreen, ColorReset)
+	} else {
+		fmt.Printf("%sPost updated successfully!%s\n", ColorGreen, ColorReset)
+	}
+
+	// remember the post so the next run updates it
+	if frontmatter != nil && frontmatter.ID == "" && postResp.Post.ID != "" {
+		if err := writeFrontmatterID(filename, content, postResp.Post.ID); err != nil {
+			fmt.Printf("%sWarning: failed to save the post id to %s: %v%s\n", ColorYellow, filename, err, ColorReset)
+		} else {
+			fmt.Printf("%sSaved id to %s%s\n", ColorBlue, filename, ColorReset)
+		}
+	}
+
+	printIssues(postResp.Warnings, postResp.Errors)
+	fmt.Printf("%sTitle: %s%s\n", ColorGreen, postResp.Post.Title, ColorReset)
+	fmt.Printf("%sID: %s%s\n", ColorGreen, postResp.Post.ID, ColorReset)
+
+	// Show permalink if available
+	if postResp.Post.Permalink != "" {
+		fmt.Printf("%sURL: %s%s\n", ColorPurple, postResp.Post.Permalink, ColorReset)
+	}
+
+	fmt.Printf("%sAdmin: %s/_/#/collections/posts/records/%s%s\n",
+		ColorBlue, appURL, postResp.Post.ID, ColorReset)
+
+	return nil
+}
+
+func updateMarkdown(appURL, token, postID, filename string, dryRun bool) error {
+	fmt.Printf("%sUpdating post %s with %s...%s\n", ColorBlue, postID, filename, ColorReset)
+
+	assets, saveAssets, err := fileAssetCache(filename)
+	if err != nil {
+		return err
+	}
+
+	_, _, processedContent, err := preparePost(appURL, token, filename, dryRun, assets)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := saveAssets(); err != nil {
+			fmt.Printf("%sWarning: failed to save the upload cache: %v%s\n", ColorYellow, err, ColorReset)
+		}
+	}
+
+	url := appURL + "/api/markdown/posts/" + postID
+	if dryRun {
+		url += "?dry_run=1"
+	}
+	body, err := apiRequest("PUT", url, token, "text/plain", strings.NewReader(processedContent))
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return printDryRun(body)
+	}
+
+	var postResp PostResponse
+	err = json.Unmarshal(body, &postResp)
+	if err != nil {
+		fmt.Printf("%sPost updated successfully!%s\n", ColorGreen, ColorReset)
 		fmt.Printf("%sServer response: %s%s\n", ColorBlue, string(body), ColorReset)
 		return nil
 	}
 
-	fmt.Printf("%sPost created successfully!%s\n", ColorGreen, ColorReset)
+	fmt.Printf("%sPost updated successfully!%s\n", ColorGreen, ColorReset)
+	printIssues(postResp.Warnings, postResp.Errors)
 	fmt.Printf("%sTitle: %s%s\n", ColorGreen, postResp.Post.Title, ColorReset)
 	fmt.Printf("%sID: %s%s\n", ColorGreen, postResp.Post.ID, ColorReset)
 
@@ -524,95 +1163,581 @@ func postMarkdown(appURL, token, filename string) error {
 	return nil
 }
 
-func updateMarkdown(appURL, token, postID, filename string) error {
-	// check if file exists
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return fmt.Errorf("file '%s' not found", filename)
+func deletePost(appURL, token, post string, purge bool) error {
+	deleteURL := appURL + "/api/markdown/posts/" + url.PathEscape(post)
+	if purge {
+		deleteURL += "?purge=1"
 	}
 
-	data, err := os.ReadFile(filename)
+	if _, err := apiRequest("DELETE", deleteURL, token, "", nil); err != nil {
+		return err
+	}
+
+	if purge {
+		fmt.Printf("%sPost %s deleted%s\n", ColorGreen, post, ColorReset)
+		return nil
+	}
+	fmt.Printf("%sPost %s moved to the trash%s\n", ColorGreen, post, ColorReset)
+	fmt.Printf("%sPost the file again to restore it, or use --purge to delete it for good%s\n", ColorBlue, ColorReset)
+	return nil
+}
+
+func unpublishPost(appURL, token, post string) error {
+	body, err := apiRequest("POST", appURL+"/api/markdown/posts/"+url.PathEscape(post)+"/unpublish", token, "", nil)
 	if err != nil {
-		return fmt.Errorf("failed to read file %s: %v", filename, err)
+		return err
 	}
 
-	fmt.Printf("%sUpdating post %s with %s...%s\n", ColorBlue, postID, filename, ColorReset)
+	var postResp PostResponse
+	if err := json.Unmarshal(body, &postResp); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
 
-	content := string(data)
+	fmt.Printf("%sPost unpublished%s\n", ColorGreen, ColorReset)
+	fmt.Printf("%sTitle: %s%s\n", ColorGreen, postResp.Post.Title, ColorReset)
+	fmt.Printf("%sID: %s%s\n", ColorGreen, postResp.Post.ID, ColorReset)
+	return nil
+}
 
-	// Parse frontmatter
-	frontmatter, markdownContent, err := parseFrontmatter(content)
+// apiRequest sends an authenticated request, with an optional body of
+// contentType, and returns the body of a 200
+func apiRequest(method, endpoint, token, contentType string, content io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, endpoint, content)
 	if err != nil {
-		return fmt.Errorf("failed to parse frontmatter: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
 	}
 
-	// Process featured image if present
-	if frontmatter != nil {
-		if err := processFeaturedImage(frontmatter, appURL, token); err != nil {
-			return fmt.Errorf("failed to process featured image: %v", err)
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, serverError(resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+func showHistory(appURL, token, post string) error {
+	body, err := apiRequest("GET", appURL+"/api/markdown/posts/"+url.PathEscape(post)+"/revisions", token, "", nil)
+	if err != nil {
+		return err
+	}
+
+	var history HistoryResponse
+	if err := json.Unmarshal(body, &history); err != nil {
+		return fmt.Errorf("failed to parse history: %v", err)
+	}
+
+	fmt.Printf("%s%s (%s)%s\n", ColorPurple, history.Post.Title, history.Post.Slug, ColorReset)
+	if len(history.Revisions) == 0 {
+		fmt.Printf("%sNo revisions yet%s\n", ColorYellow, ColorReset)
+		return nil
+	}
+
+	for _, revision := range history.Revisions {
+		fmt.Printf("%s#%d%s %s %s%s%s %s\n",
+			ColorGreen, revision.Number, ColorReset,
+			revision.Created,
+			ColorBlue, revision.ID, ColorReset,
+			revision.Title)
+	}
+	return nil
+}
+
+// showDiff prints a unified diff between two revisions, coloured like git
+func showDiff(appURL, token, post, from, to string) error {
+	query := url.Values{}
+	if from != "" {
+		query.Set("from", from)
+	}
+	if to != "" {
+		query.Set("to", to)
+	}
+
+	diffURL := appURL + "/api/markdown/posts/" + url.PathEscape(post) + "/diff"
+	if len(query) > 0 {
+		diffURL += "?" + query.Encode()
+	}
+
+	body, err := apiRequest("GET", diffURL, token, "", nil)
+	if err != nil {
+		return err
+	}
+
+	if len(body) == 0 {
+		fmt.Printf("%sNo changes%s\n", ColorYellow, ColorReset)
+		return nil
+	}
+
+	for _, line := range strings.SplitAfter(string(body), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			fmt.Printf("%s%s%s", ColorPurple, line, ColorReset)
+		case strings.HasPrefix(line, "@@"):
+			fmt.Printf("%s%s%s", ColorBlue, line, ColorReset)
+		case strings.HasPrefix(line, "+"):
+			fmt.Printf("%s%s%s", ColorGreen, line, ColorReset)
+		case strings.HasPrefix(line, "-"):
+			fmt.Printf("%s%s%s", ColorRed, line, ColorReset)
+		default:
+			fmt.Print(line)
 		}
 	}
+	return nil
+}
 
-	// Process assets in markdown content
-	processedMarkdown, err := processAssets(markdownContent, appURL, token)
+func restoreRevision(appURL, token, revisionID string, dryRun bool) error {
+	restoreURL := appURL + "/api/markdown/revisions/" + url.PathEscape(revisionID) + "/restore"
+	if dryRun {
+		restoreURL += "?dry_run=1"
+	}
+
+	body, err := apiRequest("POST", restoreURL, token, "", nil)
 	if err != nil {
-		return fmt.Errorf("failed to process assets: %v", err)
+		return err
 	}
 
-	// Reconstruct content with updated frontmatter
-	var processedContent string
-	if frontmatter != nil {
-		processedContent, err = reconstructContent(frontmatter, processedMarkdown)
-		if err != nil {
-			return fmt.Errorf("failed to reconstruct content: %v", err)
+	if dryRun {
+		return printDryRun(body)
+	}
+
+	var postResp PostResponse
+	if err := json.Unmarshal(body, &postResp); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	fmt.Printf("%sPost restored successfully!%s\n", ColorGreen, ColorReset)
+	printIssues(postResp.Warnings, postResp.Errors)
+	fmt.Printf("%sTitle: %s%s\n", ColorGreen, postResp.Post.Title, ColorReset)
+	fmt.Printf("%sID: %s%s\n", ColorGreen, postResp.Post.ID, ColorReset)
+	if postResp.Post.Permalink != "" {
+		fmt.Printf("%sURL: %s%s\n", ColorPurple, postResp.Post.Permalink, ColorReset)
+	}
+	return nil
+}
+
+// printIssues lists frontmatter warnings in yellow and errors in red
+func printIssues(warnings, errors []Issue) {
+	for _, issue := range warnings {
+		fmt.Printf("%sWarning: %s%s\n", ColorYellow, formatIssue(issue), ColorReset)
+	}
+	for _, issue := range errors {
+		fmt.Printf("%sError: %s%s\n", ColorRed, formatIssue(issue), ColorReset)
+	}
+}
+
+func formatIssue(issue Issue) string {
+	if issue.Field == "" {
+		return issue.Message
+	}
+	return fmt.Sprintf("%s: %s", issue.Field, issue.Message)
+}
+
+// serverError prints any issues in a rejected response and returns its message
+func serverError(status int, body []byte) error {
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Message == "" {
+		return fmt.Errorf("server error (%d): %s", status, string(body))
+	}
+
+	printIssues(errResp.Warnings, errResp.Errors)
+	return fmt.Errorf("%s (%d)", errResp.Message, status)
+}
+
+// printDryRun shows the plan from a dry run response
+func printDryRun(body []byte) error {
+	var dryRunResp DryRunResponse
+	if err := json.Unmarshal(body, &dryRunResp); err != nil {
+		return fmt.Errorf("failed to parse dry run response: %v", err)
+	}
+
+	plan := dryRunResp.Plan
+	fmt.Printf("%sDry run, nothing was saved%s\n", ColorPurple, ColorReset)
+	printIssues(dryRunResp.Warnings, dryRunResp.Errors)
+	fmt.Printf("%sSlug: %s%s\n", ColorGreen, plan.Slug, ColorReset)
+	if plan.Permalink != "" {
+		fmt.Printf("%sURL: %s%s\n", ColorPurple, plan.Permalink, ColorReset)
+	}
+	printList("New tags", plan.NewTags)
+	printList("New collections", plan.NewCollections)
+	printList("Contexts", plan.Contexts)
+
+	if len(plan.Chapters) > 0 {
+		fmt.Printf("%sChapters:%s\n", ColorBlue, ColorReset)
+		printChapters(plan.Chapters, 1)
+	}
+
+	for _, job := range plan.Crossposts {
+		fmt.Printf("%sCrosspost: %s %s%s\n", ColorBlue, job.Type, job.Platform, ColorReset)
+	}
+
+	fmt.Printf("%sRendered HTML:%s\n%s\n", ColorBlue, ColorReset, plan.ContentHTML)
+	return nil
+}
+
+func printList(label string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Printf("%s%s: %s%s\n", ColorBlue, label, strings.Join(items, ", "), ColorReset)
+}
+
+func printChapters(chapters []ChapterNode, depth int) {
+	for _, chapter := range chapters {
+		fmt.Printf("%s- %s\n", strings.Repeat("  ", depth), chapter.Title)
+		printChapters(chapter.Children, depth+1)
+	}
+}
+
+// syncManifestName is the file sync keeps in the directory it mirrors
+const syncManifestName = ".feed-sync.json"
+
+// SyncManifest is what sync knew about a directory after its last run,
+// keyed by the slash separated path of each file in it
+type SyncManifest struct {
+	Files map[string]*SyncEntry `json:"files"`
+}
+
+// SyncEntry ties a file to its post. the hashes are of the file and of the
+// server's export as of the last sync, so either side changing can be told
+// apart from both changing
+type SyncEntry struct {
+	PostID     string                `json:"post_id"`
+	Hash       string                `json:"hash"`
+	RemoteHash string                `json:"remote_hash"`
+	Uploads    map[string]SyncUpload `json:"uploads,omitempty"`
+}
+
+// SyncUpload is an asset sync uploaded, with the hash of the local file
+type SyncUpload struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+	Hash string `json:"hash,omitempty"`
+}
+
+type syncAction int
+
+const (
+	syncUnchanged syncAction = iota
+	syncPushed
+	syncPulled
+	syncBehind // changed on the server but not pulled
+	syncConflict
+)
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func loadSyncManifest(dir string) (*SyncManifest, error) {
+	manifest := &SyncManifest{}
+
+	data, err := os.ReadFile(filepath.Join(dir, syncManifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", syncManifestName, err)
 		}
-	} else {
-		processedContent = processedMarkdown
 	}
 
-	url := appURL + "/api/markdown/posts/" + postID
-	req, err := http.NewRequest("PUT", url, strings.NewReader(processedContent))
+	if manifest.Files == nil {
+		manifest.Files = map[string]*SyncEntry{}
+	}
+	return manifest, nil
+}
+
+func (m *SyncManifest) save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return err
 	}
+	return os.WriteFile(filepath.Join(dir, syncManifestName), append(data, '\n'), 0644)
+}
 
+// fetchExport downloads a post as markdown. found is false when the post no
+// longer exists on the server
+func fetchExport(appURL, token, postID string) ([]byte, bool, error) {
+	req, err := http.NewRequest("GET", appURL+"/api/markdown/posts/"+url.PathEscape(postID), nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %v", err)
+	}
 	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "text/plain")
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return nil, false, fmt.Errorf("failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return nil, false, fmt.Errorf("failed to read response: %v", err)
 	}
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("server error (%d): %s", resp.StatusCode, string(body))
+		return nil, false, serverError(resp.StatusCode, body)
 	}
+	return body, true, nil
+}
 
-	var postResp PostResponse
-	err = json.Unmarshal(body, &postResp)
+// syncDir mirrors the markdown files under dir against the server. files are
+// pushed when they changed since the last sync and, with pull, replaced by
+// the server's export when the post was edited there. a file that changed on
+// both sides is left alone and reported
+func syncDir(appURL, token, dir string, pull, dryRun bool) error {
+	info, err := os.Stat(dir)
 	if err != nil {
-		fmt.Printf("%sPost updated successfully!%s\n", ColorGreen, ColorReset)
-		fmt.Printf("%sServer response: %s%s\n", ColorBlue, string(body), ColorReset)
+		return fmt.Errorf("directory '%s' not found", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' isn't a directory", dir)
+	}
+
+	manifest, err := loadSyncManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(path), ".md") {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
 		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %v", err)
 	}
 
-	fmt.Printf("%sPost updated successfully!%s\n", ColorGreen, ColorReset)
-	fmt.Printf("%sTitle: %s%s\n", ColorGreen, postResp.Post.Title, ColorReset)
-	fmt.Printf("%sID: %s%s\n", ColorGreen, postResp.Post.ID, ColorReset)
+	fmt.Printf("%sSyncing %d files in %s...%s\n", ColorBlue, len(files), dir, ColorReset)
 
-	// Show permalink if available
-	if postResp.Post.Permalink != "" {
-		fmt.Printf("%sURL: %s%s\n", ColorPurple, postResp.Post.Permalink, ColorReset)
+	counts := map[syncAction]int{}
+	failed := 0
+	seen := map[string]bool{}
+
+	for _, rel := range files {
+		seen[rel] = true
+		action, err := syncFile(appURL, token, dir, rel, manifest, pull, dryRun)
+		if err != nil {
+			fmt.Printf("%sFailed %s: %v%s\n", ColorRed, rel, err, ColorReset)
+			failed++
+			continue
+		}
+		counts[action]++
 	}
 
-	fmt.Printf("%sAdmin: %s/_/#/collections/posts/records/%s%s\n",
-		ColorBlue, appURL, postResp.Post.ID, ColorReset)
+	// files the manifest knows that are gone locally come back with --pull
+	var missing []string
+	for rel := range manifest.Files {
+		if !seen[rel] && manifest.Files[rel].PostID != "" {
+			missing = append(missing, rel)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, rel := range missing {
+		if !pull {
+			fmt.Printf("%s%s is missing locally, run with --pull to download it%s\n", ColorYellow, rel, ColorReset)
+			counts[syncBehind]++
+			continue
+		}
+		if err := pullSyncFile(appURL, token, dir, rel, manifest.Files[rel], dryRun); err != nil {
+			fmt.Printf("%sFailed %s: %v%s\n", ColorRed, rel, err, ColorReset)
+			failed++
+			continue
+		}
+		counts[syncPulled]++
+	}
+
+	if !dryRun {
+		if err := manifest.save(dir); err != nil {
+			return fmt.Errorf("failed to save %s: %v", syncManifestName, err)
+		}
+	}
+
+	verb := ""
+	if dryRun {
+		verb = "would be "
+	}
+	fmt.Printf("%s%d %spushed, %d %spulled, %d unchanged%s\n",
+		ColorGreen, counts[syncPushed], verb, counts[syncPulled], verb, counts[syncUnchanged], ColorReset)
+	if counts[syncBehind] > 0 {
+		fmt.Printf("%s%d changed on the server, not pulled%s\n", ColorYellow, counts[syncBehind], ColorReset)
+	}
+
+	if counts[syncConflict] > 0 || failed > 0 {
+		return fmt.Errorf("%d conflicts, %d failed", counts[syncConflict], failed)
+	}
+	return nil
+}
+
+// syncFile works out which side of a file changed since the last sync and
+// acts on it. a file sync hasn't seen before is pushed, even when it names
+// an existing post, as there is nothing to compare the server against
+func syncFile(appURL, token, dir, rel string, manifest *SyncManifest, pull, dryRun bool) (syncAction, error) {
+	filename := filepath.Join(dir, filepath.FromSlash(rel))
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return syncUnchanged, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	entry := manifest.Files[rel]
+	localChanged := entry == nil || entry.Hash != contentHash(data)
+
+	remoteChanged := false
+	if entry != nil && entry.PostID != "" {
+		remote, found, err := fetchExport(appURL, token, entry.PostID)
+		if err != nil {
+			return syncUnchanged, err
+		}
+		if !found {
+			return syncUnchanged, fmt.Errorf("post %s no longer exists on the server", entry.PostID)
+		}
+		remoteChanged = contentHash(remote) != entry.RemoteHash
+	}
+
+	switch {
+	case localChanged && remoteChanged:
+		fmt.Printf("%sConflict: %s changed locally and on the server%s\n", ColorRed, rel, ColorReset)
+		return syncConflict, nil
+
+	case remoteChanged:
+		if !pull {
+			fmt.Printf("%s%s changed on the server, run with --pull to download it%s\n", ColorYellow, rel, ColorReset)
+			return syncBehind, nil
+		}
+		return syncPulled, pullSyncFile(appURL, token, dir, rel, entry, dryRun)
+
+	case localChanged:
+		if dryRun {
+			fmt.Printf("%sWould push %s%s\n", ColorYellow, rel, ColorReset)
+			return syncPushed, nil
+		}
+		if entry == nil {
+			entry = &SyncEntry{}
+			manifest.Files[rel] = entry
+		}
+		return syncPushed, pushSyncFile(appURL, token, dir, rel, entry)
+	}
+
+	return syncUnchanged, nil
+}
+
+// pushSyncFile upserts a file and records the server's export of the result
+func pushSyncFile(appURL, token, dir, rel string, entry *SyncEntry) error {
+	filename := filepath.Join(dir, filepath.FromSlash(rel))
+	fmt.Printf("%sPushing %s...%s\n", ColorBlue, rel, ColorReset)
+
+	if entry.Uploads == nil {
+		entry.Uploads = map[string]SyncUpload{}
+	}
+	assets := &assetCache{dir: filepath.Dir(filename), uploads: entry.Uploads}
+
+	content, frontmatter, processedContent, err := preparePost(appURL, token, filename, false, assets)
+	if err != nil {
+		return err
+	}
+
+	body, err := apiRequest("PUT", appURL+"/api/markdown/posts", token, "text/plain", strings.NewReader(processedContent))
+	if err != nil {
+		return err
+	}
+
+	var postResp PostResponse
+	if err := json.Unmarshal(body, &postResp); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+	printIssues(postResp.Warnings, postResp.Errors)
+
+	if frontmatter != nil && frontmatter.ID == "" && postResp.Post.ID != "" {
+		if err := writeFrontmatterID(filename, content, postResp.Post.ID); err != nil {
+			fmt.Printf("%sWarning: failed to save the post id to %s: %v%s\n", ColorYellow, rel, err, ColorReset)
+		}
+	}
+
+	// hash the file as it is now, with the id written in
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+
+	remote, found, err := fetchExport(appURL, token, postResp.Post.ID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("post %s not found after pushing", postResp.Post.ID)
+	}
+
+	entry.PostID = postResp.Post.ID
+	entry.Hash = contentHash(data)
+	entry.RemoteHash = contentHash(remote)
+
+	if postResp.Created {
+		fmt.Printf("%sCreated %s%s\n", ColorGreen, rel, ColorReset)
+	} else {
+		fmt.Printf("%sUpdated %s%s\n", ColorGreen, rel, ColorReset)
+	}
+	return nil
+}
+
+// pullSyncFile replaces a file with the server's export of its post
+func pullSyncFile(appURL, token, dir, rel string, entry *SyncEntry, dryRun bool) error {
+	if dryRun {
+		fmt.Printf("%sWould pull %s%s\n", ColorYellow, rel, ColorReset)
+		return nil
+	}
+
+	remote, found, err := fetchExport(appURL, token, entry.PostID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("post %s no longer exists on the server", entry.PostID)
+	}
+
+	filename := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, remote, 0644); err != nil {
+		return err
+	}
 
+	entry.Hash = contentHash(remote)
+	entry.RemoteHash = entry.Hash
+	fmt.Printf("%sPulled %s%s\n", ColorGreen, rel, ColorReset)
 	return nil
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"feed/views"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type collectionCount struct {
+	Collection string `db:"collection"`
+	Count      int    `db:"count"`
+}
+
+func (app *App) collectionSinglePage(re *core.RequestEvent) error {
+	slug := re.Request.PathValue("slug")
+	collection, err := app.pb.FindFirstRecordByFilter("collections", "slug = {:slug}", map[string]any{"slug": slug})
+	if err != nil {
+		return re.NotFoundError("Collection not found", err)
+	}
+
+	posts, err := app.findCollectionPosts(collection.Id)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching collection posts", "collection", slug, "error", err)
+		return re.InternalServerError("Failed to load collection", err)
+	}
+
+	app.expandFeedPosts(posts)
+
+	component := views.CollectionSinglePage(collection, posts)
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// findCollectionPosts returns the collection's visible posts in series order
+func (app *App) findCollectionPosts(collectionID string) ([]*core.Record, error) {
+	collectionPosts, err := app.pb.FindRecordsByFilter(
+		"collection_posts",
+		"collection = {:collectionId} && "+visibleFilter("post."),
+		"order,post.created",
+		0,
+		0,
+		map[string]any{"collectionId": collectionID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.junctionPosts(collectionPosts)
+}
+
+// loadCollectionPostCounts sets post_count on each collection to the number
+// of visible posts in it
+func (app *App) loadCollectionPostCounts(collections []*core.Record) error {
+	var counts []collectionCount
+	err := app.pb.DB().
+		Select("collection_posts.collection AS collection", "COUNT(*) AS count").
+		From("collection_posts").
+		InnerJoin("posts", dbx.NewExp("posts.id = collection_posts.post")).
+		Where(dbx.NewExp(visibleSQL("posts"))).
+		GroupBy("collection_posts.collection").
+		All(&counts)
+	if err != nil {
+		return err
+	}
+
+	byCollection := make(map[string]int, len(counts))
+	for _, count := range counts {
+		byCollection[count.Collection] = count.Count
+	}
+
+	for _, collection := range collections {
+		collection.Set("post_count", byCollection[collection.Id])
+	}
+	return nil
+}
+
+// seriesNavigation finds the neighbouring posts in every collection the post
+// belongs to. expects the post's collections to be loaded already
+func (app *App) seriesNavigation(post *core.Record) []views.SeriesNav {
+	var series []views.SeriesNav
+
+	for _, collection := range GetPostCollections(post) {
+		members, err := app.findCollectionPosts(collection.Id)
+		if err != nil {
+			app.pb.Logger().Error("Failed to load series", "collection_id", collection.Id, "error", err)
+			continue
+		}
+
+		for i, member := range members {
+			if member.Id != post.Id {
+				continue
+			}
+
+			nav := views.SeriesNav{
+				Collection: collection,
+				Position:   i + 1,
+				Total:      len(members),
+			}
+			if i > 0 {
+				nav.Previous = members[i-1]
+			}
+			if i < len(members)-1 {
+				nav.Next = members[i+1]
+			}
+			series = append(series, nav)
+			break
+		}
+	}
+
+	return series
+}
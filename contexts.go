@@ -0,0 +1,70 @@
+package main
+
+import (
+	"feed/views"
+	"net/url"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func (app *App) contextSinglePage(re *core.RequestEvent) error {
+	context, err := app.findContextByTitle(re.Request.PathValue("title"))
+	if err != nil {
+		return re.NotFoundError("Context not found", err)
+	}
+
+	errs := app.pb.ExpandRecord(context, []string{"logo", "explainer_post"}, nil)
+	if len(errs) > 0 {
+		app.pb.Logger().Error("Failed to expand context relations", "context_id", context.Id, "errors", errs)
+	}
+
+	// the explainer is shown in full, whether or not it's in the feed, but
+	// not once it's been trashed
+	var explainerHTML string
+	if explainer := context.ExpandedOne("explainer_post"); explainer != nil && explainer.GetDateTime("deleted_at").IsZero() {
+		explainerHTML, err = app.postContentHTML(explainer)
+		if err != nil {
+			app.pb.Logger().Error("Failed to render explainer post", "post_id", explainer.Id, "error", err)
+		}
+	}
+
+	query := re.Request.URL.Query()
+	feedQuery := parseFeedQuery(query)
+	feedQuery.Context = context.GetString("title")
+
+	posts, cursor, err := app.findFeedPosts(feedQuery)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching context posts", "context_id", context.Id, "error", err)
+		return re.InternalServerError("Failed to load posts", err)
+	}
+
+	basePath := "/contexts/" + url.PathEscape(context.GetString("title"))
+	component := views.ContextSinglePage(context, explainerHTML, posts, feedPagination(basePath, basePath+"/posts", query, cursor))
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// contextPostsPartial renders the next batch of a context's posts for htmx
+func (app *App) contextPostsPartial(re *core.RequestEvent) error {
+	context, err := app.findContextByTitle(re.Request.PathValue("title"))
+	if err != nil {
+		return re.NotFoundError("Context not found", err)
+	}
+
+	query := re.Request.URL.Query()
+	feedQuery := parseFeedQuery(query)
+	feedQuery.Context = context.GetString("title")
+
+	posts, cursor, err := app.findFeedPosts(feedQuery)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching context posts", "context_id", context.Id, "error", err)
+		return re.InternalServerError("Failed to load posts", err)
+	}
+
+	basePath := "/contexts/" + url.PathEscape(context.GetString("title"))
+	component := views.PostsBatch(posts, feedPagination(basePath, basePath+"/posts", query, cursor))
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+func (app *App) findContextByTitle(title string) (*core.Record, error) {
+	return app.pb.FindFirstRecordByFilter("contexts", "title = {:title}", map[string]any{"title": title})
+}
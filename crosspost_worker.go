@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	crosspostBatchSize   = 10
+	crosspostMaxAttempts = 5
+	// the delay before a retry doubles with each failed attempt, up to the max
+	crosspostBaseDelay = time.Minute
+	crosspostMaxDelay  = 2 * time.Hour
+	// a job processing for longer than this was lost with its worker
+	crosspostClaimTimeout = 15 * time.Minute
+	crosspostJobTimeout   = 2 * time.Minute
+)
+
+// permanentError is a job failure that retrying won't fix, so the job goes
+// straight to the dead letter state
+type permanentError struct {
+	Err error
+}
+
+func (e *permanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.Err
+}
+
+func permanent(err error) error {
+	return &permanentError{Err: err}
+}
+
+// crosspostWorker keeps cron runs from overlapping when jobs are slow
+var crosspostWorker sync.Mutex
+
+// processCrosspostJobs is run by cron to publish queued jobs and retry
+// failed ones once their backoff has passed. jobs that keep failing end up
+// Dead, and can be retried by setting them back to Queued with 0 attempts
+func (app *App) processCrosspostJobs() {
+	if !crosspostWorker.TryLock() {
+		return
+	}
+	defer crosspostWorker.Unlock()
+
+	app.requeueLostCrossposts()
+
+	for range crosspostBatchSize {
+		job, err := app.claimCrosspostJob()
+		if err != nil {
+			app.pb.Logger().Error("Failed to claim crosspost job", "error", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+		app.runCrosspostJob(job)
+	}
+}
+
+// claimCrosspostJob marks the oldest runnable job as Processing and returns
+// it, or nil when there's nothing to do
+func (app *App) claimCrosspostJob() (*core.Record, error) {
+	// each platform's jobs wait while its own token needs re-authentication
+	var platforms []string
+	params := dbx.Params{}
+	for _, name := range crosspostPlatformNames() {
+		platform := crosspostPlatforms[name]
+		if platform.Publisher == nil {
+			continue
+		}
+		key := fmt.Sprintf("platform%d", len(platforms))
+		platforms = append(platforms, "(platform = {:"+key+"} && instagram_account."+platform.NeedsReauth+" != true)")
+		params[key] = name
+	}
+	if len(platforms) == 0 {
+		return nil, nil
+	}
+
+	jobs, err := app.pb.FindRecordsByFilter(
+		"crosspost_queue",
+		"(status = 'Queued' || (status = 'Failure' && next_attempt_at <= @now)) && ("+strings.Join(platforms, " || ")+")",
+		"created",
+		1,
+		0,
+		params,
+	)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+
+	job := jobs[0]
+	job.Set("status", "Processing")
+	job.Set("claimed_at", types.NowDateTime())
+	job.Set("attempts", job.GetInt("attempts")+1)
+	if err := app.pb.Save(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// requeueLostCrossposts fails jobs left Processing by a worker that
+// stopped, so they're retried like any other failure
+func (app *App) requeueLostCrossposts() {
+	lost, err := app.pb.FindRecordsByFilter(
+		"crosspost_queue",
+		"status = 'Processing' && claimed_at < {:cutoff}",
+		"",
+		0,
+		0,
+		dbx.Params{"cutoff": time.Now().Add(-crosspostClaimTimeout).UTC().Format(types.DefaultDateLayout)},
+	)
+	if err != nil {
+		app.pb.Logger().Error("Failed to find lost crosspost jobs", "error", err)
+		return
+	}
+
+	for _, job := range lost {
+		app.failCrosspostJob(job, errors.New("the worker stopped while the job was running"))
+	}
+}
+
+func (app *App) runCrosspostJob(job *core.Record) {
+	platform, ok := crosspostPlatforms[job.GetString("platform")]
+	if !ok || platform.Publisher == nil {
+		app.failCrosspostJob(job, permanent(fmt.Errorf("no publisher for %s", job.GetString("platform"))))
+		return
+	}
+
+	post, err := app.pb.FindRecordById("posts", job.GetString("post"))
+	if err != nil {
+		app.failCrosspostJob(job, permanent(fmt.Errorf("post not found: %w", err)))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), crosspostJobTimeout)
+	defer cancel()
+
+	remoteID, remoteURL, err := app.publishCrosspost(ctx, platform.Publisher, job, post)
+	if err != nil {
+		app.failCrosspostJob(job, err)
+		return
+	}
+
+	if err := app.completeCrosspostJob(job, post, remoteID, remoteURL); err != nil {
+		app.pb.Logger().Error("Failed to record crosspost", "job_id", job.Id, "error", err)
+		return
+	}
+	app.pb.Logger().Info("Crossposted", "job_id", job.Id, "platform", job.GetString("platform"), "type", job.GetString("type"), "url", remoteURL)
+}
+
+// completeCrosspostJob marks the job done and keeps the post's url on the
+// platform in instagram_posts, clearing it for deletes
+func (app *App) completeCrosspostJob(job, post *core.Record, remoteID, remoteURL string) error {
+	return app.pb.RunInTransaction(func(txApp core.App) error {
+		job.Set("status", "Success")
+		job.Set("status_message", "")
+		job.Set("completed", types.NowDateTime())
+		job.Set("claimed_at", "")
+		job.Set("next_attempt_at", "")
+		if remoteID != "" {
+			job.Set("remote_id", remoteID)
+		}
+		if err := txApp.Save(job); err != nil {
+			return err
+		}
+
+		platform, ok := crosspostPlatforms[job.GetString("platform")]
+		account := job.GetString("instagram_account")
+		if !ok || platform.URLField == "" || account == "" {
+			return nil
+		}
+
+		if job.GetString("type") == "Delete" {
+			remoteURL = ""
+		} else if remoteURL == "" {
+			return nil
+		}
+
+		record, err := txApp.FindFirstRecordByFilter(
+			"instagram_posts",
+			"post = {:postId} && instagram_account = {:accountId}",
+			dbx.Params{"postId": post.Id, "accountId": account},
+		)
+		if err != nil {
+			collection, err := txApp.FindCollectionByNameOrId("instagram_posts")
+			if err != nil {
+				return err
+			}
+			record = core.NewRecord(collection)
+			record.Set("post", post.Id)
+			record.Set("instagram_account", account)
+		}
+
+		record.Set(platform.URLField, remoteURL)
+		record.Set("last_synced", types.NowDateTime())
+		return txApp.Save(record)
+	})
+}
+
+// failCrosspostJob records a failed attempt, scheduling a retry or moving
+// the job to Dead once it's out of attempts
+func (app *App) failCrosspostJob(job *core.Record, jobErr error) {
+	attempts := job.GetInt("attempts")
+
+	// a rejected token isn't the job's fault. it waits for the account to
+	// be re-authenticated on the job's platform without using up an attempt
+	platform, ok := crosspostPlatforms[job.GetString("platform")]
+	if account := job.GetString("instagram_account"); ok && account != "" && isInvalidTokenError(jobErr) {
+		app.markNeedsReauth(account, platform, jobErr)
+
+		job.Set("status", "Queued")
+		job.Set("status_message", jobErr.Error())
+		job.Set("claimed_at", "")
+		job.Set("attempts", attempts-1)
+		if err := app.pb.Save(job); err != nil {
+			app.pb.Logger().Error("Failed to pause crosspost job", "job_id", job.Id, "error", err)
+		}
+		return
+	}
+
+	var permanentErr *permanentError
+	dead := attempts >= crosspostMaxAttempts || errors.As(jobErr, &permanentErr)
+
+	job.Set("status_message", jobErr.Error())
+	job.Set("claimed_at", "")
+	if dead {
+		job.Set("status", "Dead")
+		job.Set("completed", types.NowDateTime())
+		job.Set("next_attempt_at", "")
+	} else {
+		job.Set("status", "Failure")
+		job.Set("next_attempt_at", time.Now().Add(crosspostBackoff(attempts)))
+	}
+
+	if err := app.pb.Save(job); err != nil {
+		app.pb.Logger().Error("Failed to record crosspost failure", "job_id", job.Id, "error", err)
+		return
+	}
+	app.pb.Logger().Warn("Crosspost failed", "job_id", job.Id, "attempts", attempts, "dead", dead, "error", jobErr)
+}
+
+// crosspostBackoff is the delay before retrying after the given number of
+// attempts
+func crosspostBackoff(attempts int) time.Duration {
+	delay := crosspostBaseDelay
+	for i := 1; i < attempts && delay < crosspostMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, crosspostMaxDelay)
+}
@@ -3,70 +3,274 @@ package main
 import (
 	"feed/views"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
 )
 
+const postsPerPage = 10
+
 func (app *App) homePage(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+	feedQuery := parseFeedQuery(query)
+
+	posts, cursor, err := app.findFeedPosts(feedQuery)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching posts", "error", err)
+		return re.InternalServerError("Failed to load posts", err)
+	}
+
+	contexts, err := app.pb.FindRecordsByFilter("contexts", "", "title", 0, 0)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching contexts", "error", err)
+	}
+
+	tags, err := app.pb.FindRecordsByFilter("tags", "", "title", 0, 0)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching tags", "error", err)
+	}
+
+	filters := views.FeedFilters{
+		Contexts: contexts,
+		Context:  feedQuery.Context,
+		Tags:     tags,
+		Selected: feedQuery.Tags,
+		MatchAll: feedQuery.MatchAll,
+	}
+
+	app.pb.Logger().Info("Posts loaded", "count", len(posts))
+	component := views.FeedPage(posts, feedPagination("/", "/feed/posts", query, cursor), filters)
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// feedPostsPartial renders the next batch of post cards for htmx
+func (app *App) feedPostsPartial(re *core.RequestEvent) error {
+	query := re.Request.URL.Query()
+
+	posts, cursor, err := app.findFeedPosts(parseFeedQuery(query))
+	if err != nil {
+		app.pb.Logger().Error("Error fetching posts", "error", err)
+		return re.InternalServerError("Failed to load posts", err)
+	}
+
+	component := views.PostsBatch(posts, feedPagination("/", "/feed/posts", query, cursor))
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// feedQuery narrows and pages the feed
+type feedQuery struct {
+	Context  string   // context title
+	Tags     []string // tag titles
+	MatchAll bool     // posts need every tag instead of any of them
+	Before   string   // created_id cursor
+	Page     int
+}
+
+func parseFeedQuery(query url.Values) feedQuery {
+	page, _ := strconv.Atoi(query.Get("page"))
+	return feedQuery{
+		Context:  strings.TrimSpace(query.Get("context")),
+		Tags:     parseTagsParam(query["tags"]),
+		MatchAll: query.Get("match") == "all",
+		Before:   query.Get("before"),
+		Page:     page,
+	}
+}
+
+// parseTagsParam accepts tags=a,b as well as repeated tags params
+func parseTagsParam(values []string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// findFeedPosts loads a page of visible posts. Before is used as a cursor,
+// with Page as an offset fallback for plain links. the returned cursor is
+// empty when there are no older posts
+func (app *App) findFeedPosts(q feedQuery) ([]*core.Record, string, error) {
+	filter := visibleFilter("")
+	params := map[string]any{}
+	offset := 0
+
+	// membership goes through the context_posts junction table
+	if q.Context != "" {
+		filter += " && context_posts_via_post.context.title ?= {:context}"
+		params["context"] = q.Context
+	}
+
+	// repeated tags.title conditions share one join, so match on the ids
+	if len(q.Tags) > 0 {
+		tagIDs, err := app.findTagIDs(q.Tags)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(tagIDs) == 0 || (q.MatchAll && len(tagIDs) < len(q.Tags)) {
+			return nil, "", nil
+		}
+
+		operator := " || "
+		if q.MatchAll {
+			operator = " && "
+		}
+
+		conditions := make([]string, 0, len(tagIDs))
+		for i, tagID := range tagIDs {
+			key := fmt.Sprintf("tag%d", i)
+			conditions = append(conditions, "tags ~ {:"+key+"}")
+			params[key] = tagID
+		}
+		filter += " && (" + strings.Join(conditions, operator) + ")"
+	}
+
+	// posts can share a created time, so the cursor carries the id too
+	if q.Before != "" {
+		before, beforeID, _ := strings.Cut(q.Before, "_")
+		if beforeID != "" {
+			filter += " && (created < {:before} || (created = {:before} && id < {:beforeId}))"
+			params["beforeId"] = beforeID
+		} else {
+			filter += " && created < {:before}"
+		}
+		params["before"] = before
+	} else if q.Page > 1 {
+		offset = (q.Page - 1) * postsPerPage
+	}
+
+	// fetch one extra to know if there's another page
 	posts, err := app.pb.FindRecordsByFilter(
 		"posts",
-		"is_visible = true",
-		"-created",
-		10,
-		0,
+		filter,
+		"-created,-id",
+		postsPerPage+1,
+		offset,
+		params,
 	)
 	if err != nil {
-		app.pb.Logger().Error("Error fetching posts", "error", err)
-		return re.InternalServerError("Failed to load posts", err)
+		return nil, "", err
 	}
 
+	var cursor string
+	if len(posts) > postsPerPage {
+		posts = posts[:postsPerPage]
+		last := posts[len(posts)-1]
+		cursor = last.GetString("created") + "_" + last.Id
+	}
+
+	app.expandFeedPosts(posts)
+	return posts, cursor, nil
+}
+
+// expandFeedPosts loads everything a PostCard needs
+func (app *App) expandFeedPosts(posts []*core.Record) {
 	// Expand direct relations (tags, featured_image)
 	errs := app.pb.ExpandRecords(posts, []string{"tags", "featured_image"}, nil)
 	if len(errs) > 0 {
 		app.pb.Logger().Error("Failed to expand direct relations", "errors", errs)
 	}
 
-	// Load junction table relations for each post
-	for _, post := range posts {
-		if err := app.loadPostRelations(post); err != nil {
-			app.pb.Logger().Error("Failed to load post relations", "post_id", post.Id, "error", err)
-		}
+	// Load junction table relations for the whole page at once
+	if err := app.loadPostsRelations(posts); err != nil {
+		app.pb.Logger().Error("Failed to load post relations", "error", err)
 	}
+}
 
-	app.pb.Logger().Info("Posts loaded", "count", len(posts))
-	component := views.FeedPage(posts)
-	return component.Render(re.Request.Context(), re.Response)
+// feedPagination builds the links for the next page from a cursor, keeping
+// the current filters
+func feedPagination(pagePath, partialPath string, query url.Values, cursor string) views.Pagination {
+	if cursor == "" {
+		return views.Pagination{}
+	}
+
+	next := url.Values{}
+	for key, values := range query {
+		next[key] = values
+	}
+	next.Del("page")
+	next.Set("before", cursor)
+
+	encoded := "?" + next.Encode()
+	return views.Pagination{
+		NextPageURL:    pagePath + encoded,
+		NextPartialURL: partialPath + encoded,
+	}
 }
 
 func (app *App) loadPostRelations(post *core.Record) error {
+	return app.loadPostsRelations([]*core.Record{post})
+}
+
+// loadPostsRelations fills expanded_contexts, expanded_collections and
+// expanded_chapters for every post, querying each junction table once
+func (app *App) loadPostsRelations(posts []*core.Record) error {
 	// Load contexts through context_posts junction table
-	if err := app.loadPostContexts(post); err != nil {
+	if err := app.loadPostsContexts(posts); err != nil {
 		return fmt.Errorf("failed to load contexts: %v", err)
 	}
 
 	// Load collections through collection_posts junction table
-	if err := app.loadPostCollections(post); err != nil {
+	if err := app.loadPostsCollections(posts); err != nil {
 		return fmt.Errorf("failed to load collections: %v", err)
 	}
 
 	// Load chapters through post_chapters table
-	if err := app.loadPostChapters(post); err != nil {
+	if err := app.loadPostsChapters(posts); err != nil {
 		return fmt.Errorf("failed to load chapters: %v", err)
 	}
 
 	return nil
 }
 
-func (app *App) loadPostContexts(post *core.Record) error {
-	// Find context_posts records for this post
-	contextPosts, err := app.pb.FindRecordsByFilter(
-		"context_posts",
-		"post = {:postId}",
-		"-created",
-		0,
-		0,
-		map[string]any{"postId": post.Id},
-	)
+// findPostsJunctionRecords fetches every row of a junction table that points
+// at one of the posts, in the given order
+func (app *App) findPostsJunctionRecords(collectionName string, posts []*core.Record, orderBy ...string) ([]*core.Record, error) {
+	postIDs := make([]any, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.Id)
+	}
+
+	var records []*core.Record
+	if len(postIDs) == 0 {
+		return records, nil
+	}
+
+	err := app.pb.RecordQuery(collectionName).
+		AndWhere(dbx.In("post", postIDs...)).
+		OrderBy(orderBy...).
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// groupByPost buckets expanded junction rows by post id, preserving order
+func groupByPost(records []*core.Record, relation string) map[string][]*core.Record {
+	grouped := make(map[string][]*core.Record)
+	for _, record := range records {
+		if related := record.ExpandedOne(relation); related != nil {
+			postID := record.GetString("post")
+			grouped[postID] = append(grouped[postID], related)
+		}
+	}
+	return grouped
+}
+
+func (app *App) loadPostsContexts(posts []*core.Record) error {
+	contextPosts, err := app.findPostsJunctionRecords("context_posts", posts, "created DESC")
 	if err != nil {
 		return err
 	}
@@ -77,28 +281,16 @@ func (app *App) loadPostContexts(post *core.Record) error {
 		return fmt.Errorf("failed to expand contexts: %v", errs)
 	}
 
-	// Extract the expanded context records
-	var contexts []*core.Record
-	for _, cp := range contextPosts {
-		if context := cp.ExpandedOne("context"); context != nil {
-			contexts = append(contexts, context)
-		}
+	contexts := groupByPost(contextPosts, "context")
+	for _, post := range posts {
+		post.Set("expanded_contexts", contexts[post.Id])
 	}
-
-	post.Set("expanded_contexts", contexts)
 	return nil
 }
 
-func (app *App) loadPostCollections(post *core.Record) error {
-	// Find collection_posts records for this post, ordered by their order field
-	collectionPosts, err := app.pb.FindRecordsByFilter(
-		"collection_posts",
-		"post = {:postId}",
-		"order", // Order by the order field
-		0,
-		0,
-		map[string]any{"postId": post.Id},
-	)
+func (app *App) loadPostsCollections(posts []*core.Record) error {
+	// Ordered by their order field so series stay in sequence
+	collectionPosts, err := app.findPostsJunctionRecords("collection_posts", posts, "[[order]] ASC")
 	if err != nil {
 		return err
 	}
@@ -109,29 +301,19 @@ func (app *App) loadPostCollections(post *core.Record) error {
 		return fmt.Errorf("failed to expand collections: %v", errs)
 	}
 
-	// Extract the expanded collection records (preserving order)
-	var collections []*core.Record
-	for _, cp := range collectionPosts {
-		if collection := cp.ExpandedOne("collection"); collection != nil {
-			collections = append(collections, collection)
-		}
+	collections := groupByPost(collectionPosts, "collection")
+	for _, post := range posts {
+		post.Set("expanded_collections", collections[post.Id])
 	}
-
-	// Store collections in a custom field
-	post.Set("expanded_collections", collections)
 	return nil
 }
 
 func (app *App) loadPostChapters(post *core.Record) error {
-	// Find post_chapters records for this post, ordered by their order field
-	chapters, err := app.pb.FindRecordsByFilter(
-		"post_chapters",
-		"post = {:postId}",
-		"order", // Order by the order field
-		0,
-		0,
-		map[string]any{"postId": post.Id},
-	)
+	return app.loadPostsChapters([]*core.Record{post})
+}
+
+func (app *App) loadPostsChapters(posts []*core.Record) error {
+	chapters, err := app.findPostsJunctionRecords("post_chapters", posts, "[[order]] ASC")
 	if err != nil {
 		return err
 	}
@@ -143,8 +325,16 @@ func (app *App) loadPostChapters(post *core.Record) error {
 		// Don't return error as parent chapters are optional
 	}
 
+	grouped := make(map[string][]*core.Record)
+	for _, chapter := range chapters {
+		postID := chapter.GetString("post")
+		grouped[postID] = append(grouped[postID], chapter)
+	}
+
 	// Store chapters in a custom field
-	post.Set("expanded_chapters", chapters)
+	for _, post := range posts {
+		post.Set("expanded_chapters", grouped[post.Id])
+	}
 	return nil
 }
 
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// graphClient calls a meta graph api. baseURL includes the version, and can
+// point at a local stand-in when testing
+type graphClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+func newGraphClient(baseURL string) *graphClient {
+	return &graphClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// graphError is an error response from the graph api
+type graphError struct {
+	Status  int
+	Code    int    `json:"code"`
+	Subcode int    `json:"error_subcode"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (e *graphError) Error() string {
+	return fmt.Sprintf("graph api error %d (code %d): %s", e.Status, e.Code, e.Message)
+}
+
+// do calls path with params as the query, decoding the response into out.
+// client errors other than rate limits won't go away on a retry, so
+// they're permanent
+func (c *graphClient) do(ctx context.Context, method, path, accessToken string, params url.Values, out any) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("access_token", accessToken)
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/"+strings.TrimLeft(path, "/")+"?"+params.Encode(), nil)
+	if err != nil {
+		return permanent(err)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		var data struct {
+			Error *graphError `json:"error"`
+		}
+		if json.Unmarshal(body, &data) != nil || data.Error == nil {
+			data.Error = &graphError{Message: strings.TrimSpace(string(body))}
+		}
+		data.Error.Status = resp.StatusCode
+
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanent(data.Error)
+		}
+		return data.Error
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid graph api response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// long-lived tokens last 60 days, refreshing at half that leaves a month
+	// of daily retries before one expires
+	instagramTokenRefreshAge = 30 * 24 * time.Hour
+	// graph api code for an expired or revoked access token
+	graphCodeInvalidToken = 190
+)
+
+// refreshInstagramTokens is run by cron to refresh long-lived tokens before
+// they expire. accounts whose refresh is rejected are marked as needing
+// re-authentication, and their instagram jobs wait until a new access_key
+// is saved. network and server errors are left for the next run. only
+// access_key is refreshed, threads_access_key is a separate threads token
+// the instagram endpoint doesn't take, and its state doesn't stop this
+func (app *App) refreshInstagramTokens() {
+	cutoff := time.Now().Add(-instagramTokenRefreshAge).UTC().Format(types.DefaultDateLayout)
+	accounts, err := app.pb.FindRecordsByFilter(
+		"instagram_accounts",
+		"access_key != '' && needs_reauth != true && ((last_token_refresh = '' && created < {:cutoff}) || (last_token_refresh != '' && last_token_refresh < {:cutoff}))",
+		"",
+		0,
+		0,
+		dbx.Params{"cutoff": cutoff},
+	)
+	if err != nil {
+		app.pb.Logger().Error("Failed to find instagram tokens to refresh", "error", err)
+		return
+	}
+
+	graph := newGraphClient(cmp.Or(os.Getenv("INSTAGRAM_GRAPH_URL"), instagramGraphURL))
+
+	for _, account := range accounts {
+		if err := app.refreshInstagramToken(graph, account); err != nil {
+			var permanentErr *permanentError
+			if errors.As(err, &permanentErr) {
+				app.markNeedsReauth(account.Id, crosspostPlatforms["Instagram"], err)
+				continue
+			}
+			app.pb.Logger().Warn("Failed to refresh instagram token", "account_id", account.Id, "error", err)
+		}
+	}
+}
+
+func (app *App) refreshInstagramToken(graph *graphClient, account *core.Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), crosspostJobTimeout)
+	defer cancel()
+
+	var refreshed struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err := graph.do(ctx, http.MethodGet, "refresh_access_token", account.GetString("access_key"), url.Values{
+		"grant_type": {"ig_refresh_token"},
+	}, &refreshed)
+	if err != nil {
+		return err
+	}
+	if refreshed.AccessToken == "" {
+		return errors.New("graph api didn't return a token")
+	}
+
+	account.Set("access_key", refreshed.AccessToken)
+	account.Set("last_token_refresh", types.NowDateTime())
+	if err := app.pb.Save(account); err != nil {
+		return err
+	}
+
+	app.pb.Logger().Info("Refreshed instagram token", "account_id", account.Id, "expires_in", refreshed.ExpiresIn)
+	return nil
+}
+
+// markNeedsReauth pauses an account's jobs on a platform until the
+// platform's token is replaced
+func (app *App) markNeedsReauth(accountID string, platform *crosspostPlatform, reason error) {
+	account, err := app.pb.FindRecordById("instagram_accounts", accountID)
+	if err != nil {
+		return
+	}
+
+	account.Set(platform.NeedsReauth, true)
+	account.Set(platform.TokenError, reason.Error())
+	if err := app.pb.Save(account); err != nil {
+		app.pb.Logger().Error("Failed to mark instagram account for re-authentication", "account_id", accountID, "field", platform.NeedsReauth, "error", err)
+		return
+	}
+	app.pb.Logger().Warn("Instagram account needs re-authentication", "account_id", accountID, "field", platform.NeedsReauth, "error", reason)
+}
+
+// isInvalidTokenError reports whether the graph api rejected the access
+// token itself, rather than the request
+func isInvalidTokenError(err error) bool {
+	var graphErr *graphError
+	return errors.As(err, &graphErr) && graphErr.Code == graphCodeInvalidToken
+}
+
+// reauthenticatedAccountHook clears a platform's re-authentication flag once
+// a new token for it is saved, which resumes its crosspost jobs
+func (app *App) reauthenticatedAccountHook(e *core.RecordEvent) error {
+	original := e.Record.Original()
+
+	if e.Record.GetString("access_key") != original.GetString("access_key") {
+		e.Record.Set("needs_reauth", false)
+		e.Record.Set("token_error", "")
+		e.Record.Set("last_token_refresh", types.NowDateTime())
+	}
+	if e.Record.GetString("threads_access_key") != original.GetString("threads_access_key") {
+		e.Record.Set("threads_needs_reauth", false)
+		e.Record.Set("threads_token_error", "")
+	}
+	return e.Next()
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
+	"github.com/spf13/cobra"
 )
 
 type App struct {
@@ -40,6 +41,9 @@ func main() {
 		return se.Next()
 	})
 	app.setupHooks()
+	app.setupCommands()
+	app.setupCron()
+	app.setupPublishers()
 	if err := pb.Start(); err != nil {
 		log.Fatal(err)
 	}
@@ -56,16 +60,71 @@ func (app *App) setupRoutes(se *core.ServeEvent) {
 		return apis.Static(os.DirFS("./pb_public/assets"), false)(e)
 	})
 	se.Router.GET("/", app.homePage)
+	se.Router.GET("/feed/posts", app.feedPostsPartial)
+	se.Router.GET("/posts/{slug}", app.postPage)
+	se.Router.GET("/search", app.searchPage)
+	se.Router.GET("/search/results", app.searchResultsPartial)
 	se.Router.GET("/links", app.linksPage)
 	se.Router.GET("/collections", app.collectionsPage)
+	se.Router.GET("/collections/{slug}", app.collectionSinglePage)
+	se.Router.GET("/tags", app.tagsPage)
+	se.Router.GET("/tags/{title}", app.tagSinglePage)
+	se.Router.GET("/tags/{title}/posts", app.tagPostsPartial)
+	se.Router.GET("/contexts/{title}", app.contextSinglePage)
+	se.Router.GET("/contexts/{title}/posts", app.contextPostsPartial)
 	se.Router.GET("/about", app.aboutPage)
 
-	// api usage for posting
-	se.Router.POST("/api/markdown/posts", app.createPostFromMarkdown).Bind(apis.RequireSuperuserAuth())
-	se.Router.PUT("/api/markdown/posts/{id}", app.updatePostFromMarkdown).Bind(apis.RequireSuperuserAuth())
+	// syndication, site wide and scoped to a tag, context or collection
+	feedFiles := map[string]feedFormat{
+		"feed.xml":  feedRSS,
+		"atom.xml":  feedAtom,
+		"feed.json": feedJSON,
+	}
+	for file, format := range feedFiles {
+		se.Router.GET("/"+file, app.siteFeed(format))
+		se.Router.GET("/tags/{title}/"+file, app.tagFeed(format))
+		se.Router.GET("/contexts/{title}/"+file, app.contextFeed(format))
+		se.Router.GET("/collections/{slug}/"+file, app.collectionFeed(format))
+	}
+
+	// api usage for posting, with an api token or as a superuser
+	se.Router.POST("/api/markdown/tokens", app.createAPITokenRoute).Bind(apis.RequireSuperuserAuth())
+	se.Router.POST("/api/markdown/uploads", app.createUpload).BindFunc(app.requireScope(scopeUploadsWrite))
+	se.Router.POST("/api/markdown/posts", app.createPostFromMarkdown).BindFunc(app.requireScope(scopePostsWrite))
+	se.Router.PUT("/api/markdown/posts", app.upsertPostFromMarkdown).BindFunc(app.requireScope(scopePostsWrite))
+	se.Router.GET("/api/markdown/posts/{id}", app.exportPostMarkdown).BindFunc(app.requireScope(scopeRead))
+	se.Router.PUT("/api/markdown/posts/{id}", app.updatePostFromMarkdown).BindFunc(app.requireScope(scopePostsWrite))
+	se.Router.DELETE("/api/markdown/posts/{id}", app.deletePost).BindFunc(app.requireScope(scopePostsWrite))
+	se.Router.POST("/api/markdown/posts/{id}/unpublish", app.unpublishPost).BindFunc(app.requireScope(scopePostsWrite))
+	se.Router.GET("/api/markdown/posts/{id}/revisions", app.postRevisionsList).BindFunc(app.requireScope(scopeRead))
+	se.Router.GET("/api/markdown/posts/{id}/diff", app.postRevisionsDiff).BindFunc(app.requireScope(scopeRead))
+	se.Router.GET("/api/markdown/revisions/{id}", app.revisionView).BindFunc(app.requireScope(scopeRead))
+	se.Router.POST("/api/markdown/revisions/{id}/restore", app.restoreRevision).BindFunc(app.requireScope(scopePostsWrite))
 }
 
 func (app *App) setupHooks() {
+	// keep the stored html in sync with admin edits
+	app.pb.OnRecordCreateRequest("posts").BindFunc(app.renderPostHook)
+	app.pb.OnRecordUpdateRequest("posts").BindFunc(app.renderPostHook)
+
+	// keep admin ui edits in the revision history
+	app.pb.OnRecordUpdateRequest("posts").BindFunc(app.revisionPostHook)
+
+	// hiding a post in the admin ui has to beat its publish_at
+	app.pb.OnRecordUpdateRequest("posts").BindFunc(app.hidePostHook)
+
+	// keep the search index in sync
+	app.pb.OnRecordAfterCreateSuccess("posts").BindFunc(app.indexPostHook)
+	app.pb.OnRecordAfterUpdateSuccess("posts").BindFunc(app.indexPostHook)
+	app.pb.OnRecordAfterDeleteSuccess("posts").BindFunc(app.unindexPostHook)
+	app.pb.OnRecordAfterUpdateSuccess("tags").BindFunc(app.reindexTagPostsHook)
+
+	// clean up after posts however they're deleted
+	app.pb.OnRecordDelete("posts").BindFunc(app.cleanupPostHook)
+
+	// resume crossposting once an account gets a new token
+	app.pb.OnRecordUpdate("instagram_accounts").BindFunc(app.reauthenticatedAccountHook)
+
 	// example: validate posts before creation
 	// app.pb.OnRecordCreateRequest("posts").BindFunc(func(re *core.RecordRequestEvent) error {
 	// 	// Custom validation logic here
@@ -83,13 +142,41 @@ func (app *App) setupHooks() {
 	// })
 }
 
+func (app *App) setupCron() {
+	// flip scheduled posts live / hidden
+	app.pb.Cron().MustAdd("publishScheduledPosts", "* * * * *", app.publishScheduledPosts)
+
+	// publish queued crossposts and retry failed ones
+	app.pb.Cron().MustAdd("processCrosspostJobs", "* * * * *", app.processCrosspostJobs)
+
+	// keep long-lived instagram tokens from expiring
+	app.pb.Cron().MustAdd("refreshInstagramTokens", "0 4 * * *", app.refreshInstagramTokens)
+}
+
+func (app *App) setupCommands() {
+	app.pb.RootCmd.AddCommand(&cobra.Command{
+		Use:   "rerender",
+		Short: "Rebuild the stored html for every post",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return app.rerenderPosts()
+		},
+	})
+	app.pb.RootCmd.AddCommand(app.tokenCommand())
+}
+
 //	func (app *App) handleAssets(re *core.RequestEvent) error {
 //		path := re.Request.PathValue("path")
 //		error := apis.Static(os.DirFS(fmt.Sprintf("/pb_public/assets/%s", path)), false)
 //
 // }
 func (app *App) createPostFromMarkdown(re *core.RequestEvent) error {
-	return app.processPost(re, false, "")
+	return app.processPost(re, ingestCreate, "")
+}
+
+// upsertPostFromMarkdown updates the post named by the frontmatter id or
+// slug, creating it when there isn't one
+func (app *App) upsertPostFromMarkdown(re *core.RequestEvent) error {
+	return app.processPost(re, ingestUpsert, "")
 }
 
 func (app *App) updatePostFromMarkdown(re *core.RequestEvent) error {
@@ -97,7 +184,7 @@ func (app *App) updatePostFromMarkdown(re *core.RequestEvent) error {
 	if postID == "" {
 		return re.BadRequestError("Post ID is required", nil)
 	}
-	return app.processPost(re, true, postID)
+	return app.processPost(re, ingestUpdate, postID)
 }
 
 func (app *App) linksPage(re *core.RequestEvent) error {
@@ -128,6 +215,10 @@ func (app *App) collectionsPage(re *core.RequestEvent) error {
 		log.Printf("Error fetching collections: %v", err)
 	}
 
+	if err := app.loadCollectionPostCounts(collections); err != nil {
+		log.Printf("Error counting collection posts: %v", err)
+	}
+
 	component := views.CollectionsPage(collections)
 	return component.Render(re.Request.Context(), re.Response)
 }
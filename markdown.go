@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+)
+
+var markdownPolicy = newMarkdownPolicy()
+
+func newMarkdownPolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	// keep fenced code languages so highlighting can hook onto them
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+	return policy
+}
+
+// renderMarkdown turns post markdown into sanitized html. headings get ids
+// taken from the post's chapter records (in order) so "#chapter-slug" links
+// land on the right heading, falling back to a plain slug for the rest.
+func (app *App) renderMarkdown(source string, chapters []*core.Record) (string, error) {
+	src := []byte(source)
+	doc := markdown.Parser().Parse(text.NewReader(src))
+
+	app.assignHeadingIDs(doc, src, chapters)
+
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, src, doc); err != nil {
+		return "", fmt.Errorf("failed to render markdown: %v", err)
+	}
+
+	return markdownPolicy.Sanitize(buf.String()), nil
+}
+
+func (app *App) assignHeadingIDs(doc ast.Node, src []byte, chapters []*core.Record) {
+	used := make(map[string]bool)
+	next := 0
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+
+		base := app.generateSlugBase(headingText(heading, src))
+		if base == "" {
+			return ast.WalkSkipChildren, nil
+		}
+
+		// chapters are stored in document order, so only look forward from
+		// the last match. code blocks can hold lines that parseChapters saw
+		// as headings, which is why this isn't a straight index lookup
+		id := ""
+		for i := next; i < len(chapters); i++ {
+			if app.generateSlugBase(chapters[i].GetString("title")) == base {
+				id = chapters[i].GetString("slug")
+				next = i + 1
+				break
+			}
+		}
+
+		if id == "" {
+			id = base
+			for counter := 1; used[id]; counter++ {
+				id = fmt.Sprintf("%s-%d", base, counter)
+			}
+		}
+
+		used[id] = true
+		heading.SetAttributeString("id", []byte(id))
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// headingText collects the plain text of a heading, including text nested in
+// emphasis, links and code spans
+func headingText(heading *ast.Heading, src []byte) string {
+	var buf bytes.Buffer
+	ast.Walk(heading, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.Text:
+			buf.Write(node.Segment.Value(src))
+		case *ast.String:
+			buf.Write(node.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return buf.String()
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// full text index over posts, kept in sync by the posts/tags record hooks
+		if _, err := app.DB().NewQuery(`
+			CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+				post_id UNINDEXED,
+				title,
+				subtitle,
+				summary,
+				content,
+				tags,
+				tokenize = 'porter unicode61'
+			)
+		`).Execute(); err != nil {
+			return err
+		}
+
+		// backfill existing posts
+		_, err := app.DB().NewQuery(`
+			INSERT INTO posts_fts (post_id, title, subtitle, summary, content, tags)
+			SELECT
+				p.id,
+				p.title,
+				p.subtitle,
+				p.summary,
+				p.content,
+				COALESCE((
+					SELECT group_concat(t.title, ' ')
+					FROM json_each(CASE WHEN json_valid(p.tags) THEN p.tags ELSE '[]' END) j
+					JOIN tags t ON t.id = j.value
+				), '')
+			FROM posts p
+		`).Execute()
+
+		return err
+	}, func(app core.App) error {
+		_, err := app.DB().NewQuery("DROP TABLE IF EXISTS posts_fts").Execute()
+
+		return err
+	})
+}
@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_685726834")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(2, []byte(`{
+			"hidden": false,
+			"id": "number2526027604",
+			"max": null,
+			"min": 1,
+			"name": "number",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// number the existing revisions of each post in the order they were saved
+		if _, err := app.DB().NewQuery(`
+			UPDATE post_revisions SET number = (
+				SELECT COUNT(*) FROM post_revisions earlier
+				WHERE earlier.post = post_revisions.post
+				AND (earlier.created < post_revisions.created
+					OR (earlier.created = post_revisions.created AND earlier.rowid <= post_revisions.rowid))
+			)
+		`).Execute(); err != nil {
+			return err
+		}
+
+		// update indexes
+		collection.RemoveIndex("idx_post_revisions_post")
+		collection.AddIndex("idx_post_revisions_post", true, "`post`, `number`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_685726834")
+		if err != nil {
+			return err
+		}
+
+		// update indexes
+		collection.RemoveIndex("idx_post_revisions_post")
+		collection.AddIndex("idx_post_revisions_post", false, "`post`, `created`", "")
+
+		// remove field
+		collection.Fields.RemoveById("number2526027604")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"feed/views"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func (app *App) postPage(re *core.RequestEvent) error {
+	slug := re.Request.PathValue("slug")
+	if slug == "" {
+		return re.NotFoundError("Post not found", nil)
+	}
+
+	post, err := app.pb.FindFirstRecordByFilter(
+		"posts",
+		"slug = {:slug} && "+visibleFilter(""),
+		map[string]any{"slug": slug},
+	)
+	if err != nil {
+		return re.NotFoundError("Post not found", err)
+	}
+
+	// Expand direct relations (tags, featured_image)
+	errs := app.pb.ExpandRecord(post, []string{"tags", "featured_image"}, nil)
+	if len(errs) > 0 {
+		app.pb.Logger().Error("Failed to expand direct relations", "post_id", post.Id, "errors", errs)
+	}
+
+	if err := app.loadPostRelations(post); err != nil {
+		app.pb.Logger().Error("Failed to load post relations", "post_id", post.Id, "error", err)
+	}
+
+	contentHTML, err := app.postContentHTML(post)
+	if err != nil {
+		app.pb.Logger().Error("Failed to render post content", "post_id", post.Id, "error", err)
+		return re.InternalServerError("Failed to render post", err)
+	}
+
+	component := views.PostPage(post, contentHTML, app.seriesNavigation(post), app.siteURL(re))
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// postContentHTML returns the stored html, rendering on the fly for posts
+// saved before content_html existed
+func (app *App) postContentHTML(post *core.Record) (string, error) {
+	if contentHTML := post.GetString("content_html"); contentHTML != "" || post.GetString("content") == "" {
+		return contentHTML, nil
+	}
+
+	chapters := GetPostChapters(post)
+	if chapters == nil {
+		if err := app.loadPostChapters(post); err != nil {
+			return "", err
+		}
+		chapters = GetPostChapters(post)
+	}
+
+	return app.renderMarkdown(post.GetString("content"), chapters)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// deletePost moves a post to the trash, or deletes it for good with ?purge=1.
+// trashed posts are hidden everywhere and come back when posted again
+func (app *App) deletePost(re *core.RequestEvent) error {
+	post, err := app.findPostByIDOrSlug(re.Request.PathValue("id"))
+	if err != nil {
+		return re.NotFoundError("Post not found", err)
+	}
+
+	purge, _ := strconv.ParseBool(re.Request.URL.Query().Get("purge"))
+	if purge {
+		// the post's delete jobs would go with it, leaving its copies on
+		// other platforms up for good. it's trashed instead, which queues
+		// them, and can be purged once they're done
+		copies, err := remoteCopies(app.pb, post)
+		if err != nil {
+			return re.InternalServerError("Failed to check crossposts", err)
+		}
+		if len(copies) > 0 {
+			err = app.pb.RunInTransaction(func(txApp core.App) error {
+				return app.trashPost(txApp, post)
+			})
+			if err != nil {
+				return re.InternalServerError("Failed to move post to trash", err)
+			}
+
+			platforms := slices.Sorted(maps.Keys(copies))
+			return re.JSON(http.StatusConflict, map[string]any{
+				"message":   fmt.Sprintf("Post is still on %s. It was moved to the trash and will be deleted there, purge it again once that's done", strings.Join(platforms, ", ")),
+				"purged":    false,
+				"platforms": platforms,
+			})
+		}
+
+		// cleanupPostHook takes care of everything hanging off the post
+		if err := app.pb.Delete(post); err != nil {
+			return re.InternalServerError("Failed to delete post", err)
+		}
+		return re.JSON(http.StatusOK, map[string]any{
+			"message": "Post deleted",
+			"purged":  true,
+		})
+	}
+
+	err = app.pb.RunInTransaction(func(txApp core.App) error {
+		return app.trashPost(txApp, post)
+	})
+	if err != nil {
+		return re.InternalServerError("Failed to move post to trash", err)
+	}
+
+	return re.JSON(http.StatusOK, map[string]any{
+		"message": "Post moved to trash",
+		"purged":  false,
+		"post":    post,
+	})
+}
+
+// unpublishPost hides a post without deleting it. the schedule is cleared
+// too, since a due publish_at would make it visible again
+func (app *App) unpublishPost(re *core.RequestEvent) error {
+	post, err := app.findPostByIDOrSlug(re.Request.PathValue("id"))
+	if err != nil {
+		return re.NotFoundError("Post not found", err)
+	}
+
+	err = app.pb.RunInTransaction(func(txApp core.App) error {
+		post.Set("is_visible", false)
+		post.Set("publish_at", "")
+		post.Set("unpublish_at", "")
+		if err := txApp.Save(post); err != nil {
+			return err
+		}
+		return dropPendingCrossposts(txApp, post)
+	})
+	if err != nil {
+		return re.InternalServerError("Failed to unpublish post", err)
+	}
+
+	return re.JSON(http.StatusOK, map[string]any{
+		"message": "Post unpublished",
+		"post":    post,
+	})
+}
+
+// trashPost hides the post and queues its removal from platforms it was
+// crossposted to. its tags, contexts and chapters stay so it can come back
+func (app *App) trashPost(txApp core.App, post *core.Record) error {
+	if post.GetDateTime("deleted_at").IsZero() {
+		post.Set("deleted_at", types.NowDateTime())
+	}
+	post.Set("is_visible", false)
+	if err := txApp.Save(post); err != nil {
+		return err
+	}
+
+	if err := dropPendingCrossposts(txApp, post); err != nil {
+		return err
+	}
+
+	copies, err := remoteCopies(txApp, post)
+	if err != nil {
+		return err
+	}
+
+	collection, err := txApp.FindCollectionByNameOrId("crosspost_queue")
+	if err != nil {
+		return err
+	}
+
+	for _, platform := range slices.Sorted(maps.Keys(copies)) {
+		// trashing again doesn't queue a second delete
+		pending, err := txApp.CountRecords(
+			"crosspost_queue",
+			dbx.HashExp{"post": post.Id, "platform": platform, "type": "Delete"},
+			dbx.In("status", "Queued", "Processing", "Failure"),
+		)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			continue
+		}
+
+		deleteJob := core.NewRecord(collection)
+		deleteJob.Set("platform", platform)
+		deleteJob.Set("type", "Delete")
+		deleteJob.Set("post", post.Id)
+		deleteJob.Set("status", "Queued")
+		deleteJob.Set("instagram_account", copies[platform])
+		if err := txApp.Save(deleteJob); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remoteCopies maps the platforms a post can be deleted from and is still
+// on to the account its copy there was made with
+func remoteCopies(txApp core.App, post *core.Record) (map[string]string, error) {
+	copies := map[string]string{}
+	for _, name := range crosspostPlatformNames() {
+		if !crosspostPlatforms[name].CanDelete {
+			continue
+		}
+
+		latest, err := txApp.FindRecordsByFilter(
+			"crosspost_queue",
+			"post = {:postId} && platform = {:platform} && status = 'Success' && remote_id != ''",
+			"-completed",
+			1,
+			0,
+			dbx.Params{"postId": post.Id, "platform": name},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(latest) > 0 && latest[0].GetString("type") != "Delete" {
+			copies[name] = latest[0].GetString("instagram_account")
+		}
+	}
+	return copies, nil
+}
+
+// dropPendingCrossposts removes create and update jobs that haven't run or
+// are waiting on a retry, so a hidden post isn't published elsewhere
+func dropPendingCrossposts(txApp core.App, post *core.Record) error {
+	jobs, err := txApp.FindRecordsByFilter(
+		"crosspost_queue",
+		"post = {:postId} && type != 'Delete' && (status = 'Queued' || status = 'Scheduled' || status = 'Failure')",
+		"",
+		0,
+		0,
+		map[string]any{"postId": post.Id},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := txApp.Delete(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupPostHook runs for every post delete, including ones from the admin
+// ui. it removes the rows pocketbase doesn't cascade and then tags left
+// without posts, in the same transaction as the delete
+func (app *App) cleanupPostHook(e *core.RecordEvent) error {
+	originalApp := e.App
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		e.App = txApp
+
+		if err := deletePostRows(txApp, e.Record); err != nil {
+			return err
+		}
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		return deleteOrphanTags(txApp, e.Record.GetStringSlice("tags"))
+	})
+	e.App = originalApp
+
+	return err
+}
+
+// deletePostRows deletes the context links and chapters of a post. collection
+// links, crosspost jobs and revisions cascade on their own
+func deletePostRows(txApp core.App, post *core.Record) error {
+	contextPosts, err := txApp.FindAllRecords("context_posts", dbx.HashExp{"post": post.Id})
+	if err != nil {
+		return err
+	}
+	for _, contextPost := range contextPosts {
+		if err := txApp.Delete(contextPost); err != nil {
+			return err
+		}
+	}
+
+	// children before their parents, so no parent_chapter needs unsetting
+	chapters, err := txApp.FindRecordsByFilter("post_chapters", "post = {:postId}", "-order", 0, 0, map[string]any{"postId": post.Id})
+	if err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		if err := txApp.Delete(chapter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteOrphanTags deletes the given tags that ingest created and no post
+// uses anymore. tags made in the admin are kept. posts in the trash still
+// count, so their tags are there when they come back
+func deleteOrphanTags(txApp core.App, tagIDs []string) error {
+	for _, tagID := range tagIDs {
+		used, err := txApp.CountRecords("posts", dbx.NewExp("EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(tags) THEN tags ELSE '[]' END) WHERE value = {:tagId})", dbx.Params{"tagId": tagID}))
+		if err != nil {
+			return err
+		}
+		if used > 0 {
+			continue
+		}
+
+		tag, err := txApp.FindRecordById("tags", tagID)
+		if err != nil || !tag.GetBool("auto_created") {
+			continue
+		}
+		if err := txApp.Delete(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// errDryRun rolls back the ingest transaction once the plan is built
+var errDryRun = errors.New("dry run")
+
+// ingestPlan is what processPost would do with a post, reported by dry runs
+type ingestPlan struct {
+	Slug           string             `json:"slug"`
+	Permalink      string             `json:"permalink"`
+	NewTags        []string           `json:"new_tags"`
+	NewCollections []string           `json:"new_collections"`
+	Contexts       []string           `json:"contexts"`
+	Chapters       []*chapterNode     `json:"chapters"`
+	ContentHTML    string             `json:"content_html"`
+	Crossposts     []plannedCrosspost `json:"crossposts"`
+}
+
+type chapterNode struct {
+	Title    string         `json:"title"`
+	Level    int            `json:"level"`
+	Children []*chapterNode `json:"children"`
+}
+
+type plannedCrosspost struct {
+	Platform string `json:"platform"`
+	Type     string `json:"type"`
+	Account  string `json:"account"`
+}
+
+// planIngest runs the ingest with txApp and reports what changed. the caller
+// is expected to roll the transaction back afterwards
+func (app *App) planIngest(txApp core.App, post *core.Record, frontmatter *PostFrontmatter, markdownContent string, queueType string) (*ingestPlan, error) {
+	newTags, err := missingTitles(txApp, "tags", frontmatter.Tags)
+	if err != nil {
+		return nil, ingestFailed("tags", "tags", err)
+	}
+
+	newCollections, err := missingTitles(txApp, "collections", frontmatter.Collections)
+	if err != nil {
+		return nil, ingestFailed("collections", "collections", err)
+	}
+
+	// jobs already queued for an existing post aren't part of this ingest
+	existingJobs := map[string]bool{}
+	if !post.IsNew() {
+		jobs, err := txApp.FindRecordsByFilter("crosspost_queue", "post = {:postId}", "", 0, 0, map[string]any{"postId": post.Id})
+		if err != nil {
+			return nil, ingestFailed("crosspost", "", err)
+		}
+		for _, job := range jobs {
+			existingJobs[job.Id] = true
+		}
+	}
+
+	if err := app.ingestPost(txApp, post, frontmatter, markdownContent, queueType); err != nil {
+		return nil, err
+	}
+
+	plan := &ingestPlan{
+		Slug:           post.GetString("slug"),
+		Permalink:      post.GetString("permalink"),
+		NewTags:        newTags,
+		NewCollections: newCollections,
+		Contexts:       []string{},
+		Chapters:       chapterTree(app.parseChapters(markdownContent)),
+		ContentHTML:    post.GetString("content_html"),
+		Crossposts:     []plannedCrosspost{},
+	}
+
+	contexts, err := txApp.FindRecordsByFilter("contexts", "context_posts_via_context.post ?= {:postId}", "title", 0, 0, map[string]any{"postId": post.Id})
+	if err != nil {
+		return nil, ingestFailed("contexts", "contexts", err)
+	}
+	for _, context := range contexts {
+		plan.Contexts = append(plan.Contexts, context.GetString("title"))
+	}
+
+	jobs, err := txApp.FindRecordsByFilter("crosspost_queue", "post = {:postId}", "created", 0, 0, map[string]any{"postId": post.Id})
+	if err != nil {
+		return nil, ingestFailed("crosspost", "", err)
+	}
+	for _, job := range jobs {
+		if existingJobs[job.Id] {
+			continue
+		}
+		plan.Crossposts = append(plan.Crossposts, plannedCrosspost{
+			Platform: job.GetString("platform"),
+			Type:     job.GetString("type"),
+			Account:  job.GetString("instagram_account"),
+		})
+	}
+
+	return plan, nil
+}
+
+// missingTitles returns the titles that don't have a record yet, in order
+func missingTitles(txApp core.App, collection string, titles []string) ([]string, error) {
+	missing := []string{}
+
+	var values []any
+	for _, title := range titles {
+		if title = strings.TrimSpace(title); title != "" {
+			values = append(values, title)
+		}
+	}
+	if len(values) == 0 {
+		return missing, nil
+	}
+
+	records, err := txApp.FindAllRecords(collection, dbx.In("title", values...))
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(records))
+	for _, record := range records {
+		found[record.GetString("title")] = true
+	}
+
+	for _, title := range values {
+		if !found[title.(string)] {
+			found[title.(string)] = true
+			missing = append(missing, title.(string))
+		}
+	}
+	return missing, nil
+}
+
+// chapterTree nests the flat chapter list by heading level
+func chapterTree(chapters []Chapter) []*chapterNode {
+	roots := []*chapterNode{}
+	var stack []*chapterNode
+
+	for _, chapter := range chapters {
+		node := &chapterNode{Title: chapter.Title, Level: chapter.Level, Children: []*chapterNode{}}
+
+		for len(stack) > 0 && stack[len(stack)-1].Level >= chapter.Level {
+			stack = stack[:len(stack)-1]
+		}
+
+		if len(stack) == 0 {
+			roots = append(roots, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, node)
+	}
+
+	return roots
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v2"
+)
+
+// exportPostMarkdown rebuilds the markdown file a post would be ingested
+// from, so posts edited in the admin ui can be pulled back down
+func (app *App) exportPostMarkdown(re *core.RequestEvent) error {
+	post, err := app.findPostByIDOrSlug(re.Request.PathValue("id"))
+	if err != nil {
+		return re.NotFoundError("Post not found", err)
+	}
+
+	markdown, err := app.postMarkdown(app.pb, post)
+	if err != nil {
+		return re.InternalServerError("Failed to export post", err)
+	}
+
+	return re.Blob(http.StatusOK, "text/markdown; charset=utf-8", []byte(markdown))
+}
+
+// postMarkdown is the frontmatter and content of a post, in a form that
+// parseFrontmatter reads back to the same post
+func (app *App) postMarkdown(txApp core.App, post *core.Record) (string, error) {
+	frontmatter := yaml.MapSlice{
+		{Key: "id", Value: post.Id},
+		{Key: "slug", Value: post.GetString("slug")},
+		{Key: "title", Value: post.GetString("title")},
+	}
+	add := func(key string, value any) {
+		frontmatter = append(frontmatter, yaml.MapItem{Key: key, Value: value})
+	}
+
+	if subtitle := post.GetString("subtitle"); subtitle != "" {
+		add("subtitle", subtitle)
+	}
+
+	tags, err := app.postTagTitles(txApp, post)
+	if err != nil {
+		return "", err
+	}
+	if len(tags) > 0 {
+		add("tags", tags)
+	}
+
+	contexts, err := app.postJunctionTitles(txApp, post, "context_posts", "context", "contexts", "j.created")
+	if err != nil {
+		return "", err
+	}
+	if len(contexts) > 0 {
+		add("contexts", contexts)
+	}
+
+	collections, err := app.postJunctionTitles(txApp, post, "collection_posts", "collection", "collections", "j.[[order]]")
+	if err != nil {
+		return "", err
+	}
+	if len(collections) > 0 {
+		add("collections", collections)
+	}
+
+	if summary := post.GetString("summary"); summary != "" {
+		add("summary", summary)
+	}
+	if image := post.GetString("featured_image"); image != "" {
+		add("featured_image", image)
+	}
+	if images := post.GetStringSlice("featured_images"); len(images) > 0 {
+		add("featured_images", images)
+	}
+
+	flags, err := app.postCrosspostFlags(txApp, post)
+	if err != nil {
+		return "", err
+	}
+	for _, flag := range flags {
+		add(flag, true)
+	}
+
+	// a schedule decides visibility on its own, is_visible next to it
+	// would only be warned about
+	publishAt := post.GetDateTime("publish_at")
+	unpublishAt := post.GetDateTime("unpublish_at")
+	if publishAt.IsZero() {
+		add("is_visible", post.GetBool("is_visible"))
+	} else {
+		add("publish_at", publishAt.Time().UTC().Format(time.RFC3339))
+	}
+	if !unpublishAt.IsZero() {
+		add("unpublish_at", unpublishAt.Time().UTC().Format(time.RFC3339))
+	}
+	// a trashed post stays in the trash when its export is posted again
+	if deletedAt := post.GetDateTime("deleted_at"); !deletedAt.IsZero() {
+		add("deleted_at", deletedAt.Time().UTC().Format(time.RFC3339))
+	}
+
+	out, err := yaml.Marshal(frontmatter)
+	if err != nil {
+		return "", err
+	}
+
+	return "---\n" + string(out) + "---\n" + post.GetString("content"), nil
+}
+
+// postTagTitles returns the post's tag titles in the order of its tags field
+func (app *App) postTagTitles(txApp core.App, post *core.Record) ([]string, error) {
+	ids := post.GetStringSlice("tags")
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	tags, err := txApp.FindRecordsByIds("tags", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		byID[tag.Id] = tag.GetString("title")
+	}
+
+	titles := make([]string, 0, len(tags))
+	for _, id := range ids {
+		if title, ok := byID[id]; ok {
+			titles = append(titles, title)
+		}
+	}
+	return titles, nil
+}
+
+// postJunctionTitles returns the titles linked to the post through a
+// junction collection, sorted by orderBy and then the order the links were
+// made in
+func (app *App) postJunctionTitles(txApp core.App, post *core.Record, junction, relation, target, orderBy string) ([]string, error) {
+	var rows []struct {
+		Title string `db:"title"`
+	}
+	err := txApp.DB().
+		Select("t.title").
+		From(junction+" j").
+		InnerJoin(target+" t", dbx.NewExp("t.id = j."+relation)).
+		Where(dbx.HashExp{"j.post": post.Id}).
+		OrderBy(orderBy, "j.created", "j.rowid").
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	titles := make([]string, 0, len(rows))
+	for _, row := range rows {
+		titles = append(titles, row.Title)
+	}
+	return titles, nil
+}
+
+// postCrosspostFlags are the frontmatter flags of the platforms the post is
+// crossposted to, meaning its latest job there isn't a delete. posting them
+// again queues updates, which don't publish a second copy
+func (app *App) postCrosspostFlags(txApp core.App, post *core.Record) ([]string, error) {
+	var flags []string
+	for _, name := range crosspostPlatformNames() {
+		jobs, err := txApp.FindRecordsByFilter(
+			"crosspost_queue",
+			"post = {:postId} && platform = {:platform}",
+			"-created",
+			1,
+			0,
+			dbx.Params{"postId": post.Id, "platform": name},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) > 0 && jobs[0].GetString("type") != "Delete" {
+			flags = append(flags, crosspostPlatforms[name].Flag)
+		}
+	}
+	return flags, nil
+}
@@ -2,26 +2,37 @@ package main
 
 import (
 	"bufio"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/pocketbase/pocketbase/core"
 	"gopkg.in/yaml.v2"
 )
 
 type PostFrontmatter struct {
+	ID                 string   `yaml:"id"`
+	Slug               string   `yaml:"slug"`
 	Title              string   `yaml:"title"`
 	Subtitle           string   `yaml:"subtitle"`
 	Tags               []string `yaml:"tags"`
 	Contexts           []string `yaml:"contexts"`
 	Collections        []string `yaml:"collections"`
 	IsVisible          bool     `yaml:"is_visible"`
+	PublishAt          string   `yaml:"publish_at"`
+	UnpublishAt        string   `yaml:"unpublish_at"`
+	DeletedAt          string   `yaml:"deleted_at"`
 	FeaturedImage      string   `yaml:"featured_image"`
+	FeaturedImages     []string `yaml:"featured_images"`
 	CrosspostInstagram bool     `yaml:"crosspost_instagram"`
 	CrosspostThreads   bool     `yaml:"crosspost_threads"`
 	Summary            string   `yaml:"summary"`
@@ -35,7 +46,81 @@ type Chapter struct {
 	ParentID string
 }
 
-func (app *App) processPost(re *core.RequestEvent, isUpdate bool, postID string) error {
+// ingestError names the step of a markdown ingest that failed and, when
+// there is one, the frontmatter or record field responsible
+type ingestError struct {
+	Step  string
+	Field string
+	Err   error
+}
+
+func (e *ingestError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s (%s): %v", e.Step, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Step, e.Err)
+}
+
+func (e *ingestError) Unwrap() error {
+	return e.Err
+}
+
+func ingestFailed(step, field string, err error) error {
+	return &ingestError{Step: step, Field: field, Err: err}
+}
+
+// recordFailed is ingestFailed for record saves, picking the field out of
+// pocketbase's validation errors when there is one
+func recordFailed(step string, err error) error {
+	var validationErrors validation.Errors
+	if errors.As(err, &validationErrors) {
+		fields := make([]string, 0, len(validationErrors))
+		for field := range validationErrors {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		return ingestFailed(step, fields[0], err)
+	}
+	return ingestFailed(step, "", err)
+}
+
+// ingestErrorResponse reports a failed ingest as json, with the step and
+// field when the error carries them. the failure is also listed in errors
+// next to any frontmatter warnings
+func ingestErrorResponse(re *core.RequestEvent, message string, err error, report frontmatterReport) error {
+	body := map[string]any{
+		"message": message,
+		"error":   err.Error(),
+	}
+
+	issue := frontmatterIssue{Message: err.Error()}
+
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		body["step"] = ingestErr.Step
+		body["field"] = ingestErr.Field
+		body["error"] = ingestErr.Err.Error()
+		issue = frontmatterIssue{Field: ingestErr.Field, Message: ingestErr.Err.Error()}
+	}
+
+	body["warnings"] = report.Warnings
+	body["errors"] = append(report.Errors, issue)
+
+	return re.JSON(http.StatusBadRequest, body)
+}
+
+// ingestMode is how processPost finds the post it writes to
+type ingestMode int
+
+const (
+	ingestCreate ingestMode = iota // always a new post
+	ingestUpdate                   // the post from the url
+	ingestUpsert                   // the post named by the frontmatter id or slug, if any
+)
+
+// processPost ingests a markdown post. everything after parsing runs in one
+// transaction so a failed step leaves the post as it was
+func (app *App) processPost(re *core.RequestEvent, mode ingestMode, postID string) error {
 	body := re.Request.Body
 	defer body.Close()
 
@@ -52,35 +137,139 @@ func (app *App) processPost(re *core.RequestEvent, isUpdate bool, postID string)
 	content := strings.Join(lines, "\n")
 	app.pb.Logger().Info(content)
 
-	frontmatter, markdownContent, err := app.parseFrontmatter(content)
+	return app.ingestMarkdown(re, mode, postID, content)
+}
+
+// ingestMarkdown is processPost once the body has been read, shared with
+// restoring revisions
+func (app *App) ingestMarkdown(re *core.RequestEvent, mode ingestMode, postID string, content string) error {
+	frontmatter, markdownContent, report, err := app.parseFrontmatter(content)
 	if err != nil {
-		return re.BadRequestError("Failed to parse frontmatter", err)
+		return ingestErrorResponse(re, "Failed to parse frontmatter", ingestFailed("frontmatter", "", err), report)
 	}
 
-	var post *core.Record
-	if isUpdate {
-		post, err = app.pb.FindRecordById("posts", postID)
-		if err != nil {
+	if len(report.Errors) > 0 {
+		return re.JSON(http.StatusBadRequest, map[string]any{
+			"message":  "Invalid frontmatter",
+			"warnings": report.Warnings,
+			"errors":   report.Errors,
+		})
+	}
+
+	post, err := app.findIngestPost(mode, postID, frontmatter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
 			return re.NotFoundError("Post not found", err)
 		}
-	} else {
-		collection, err := app.pb.FindCollectionByNameOrId("posts")
+		return re.BadRequestError("Failed to find post", err)
+	}
+
+	created := post.IsNew()
+	queueType := "Create"
+	if !created {
+		queueType = "Update"
+	}
+
+	// dry runs do the whole ingest and then roll it back
+	dryRun, _ := strconv.ParseBool(re.Request.URL.Query().Get("dry_run"))
+
+	var plan *ingestPlan
+	err = app.pb.RunInTransaction(func(txApp core.App) error {
+		if !dryRun {
+			if !created {
+				if err := app.snapshotPost(txApp, post); err != nil {
+					return err
+				}
+			}
+			if err := app.ingestPost(txApp, post, frontmatter, markdownContent, queueType); err != nil {
+				return err
+			}
+			return app.saveRevision(txApp, post, content)
+		}
+
+		var err error
+		plan, err = app.planIngest(txApp, post, frontmatter, markdownContent, queueType)
 		if err != nil {
-			return re.BadRequestError("Posts collection not found", err)
+			return err
+		}
+		return errDryRun
+	})
+	if dryRun && errors.Is(err, errDryRun) {
+		return re.JSON(200, map[string]any{
+			"dry_run":  true,
+			"plan":     plan,
+			"message":  "Dry run, nothing was saved",
+			"warnings": report.Warnings,
+			"errors":   report.Errors,
+		})
+	}
+	if err != nil {
+		app.pb.Logger().Error("Failed to process post", "post_id", post.Id, "error", err)
+		return ingestErrorResponse(re, "Failed to process post", err, report)
+	}
+
+	return re.JSON(200, map[string]any{
+		"post":     post,
+		"created":  created,
+		"message":  "Post processed successfully",
+		"warnings": report.Warnings,
+		"errors":   report.Errors,
+	})
+}
+
+// findIngestPost loads the post an ingest writes to, or a new record when
+// there isn't one. upserts look up the frontmatter id first, then the slug,
+// and a new post keeps the id it asked for
+func (app *App) findIngestPost(mode ingestMode, postID string, frontmatter *PostFrontmatter) (*core.Record, error) {
+	switch mode {
+	case ingestUpdate:
+		return app.pb.FindRecordById("posts", postID)
+	case ingestUpsert:
+		if frontmatter.ID != "" {
+			post, err := app.pb.FindRecordById("posts", frontmatter.ID)
+			if err == nil || !errors.Is(err, sql.ErrNoRows) {
+				return post, err
+			}
+		} else if frontmatter.Slug != "" {
+			post, err := app.pb.FindFirstRecordByFilter("posts", "slug = {:slug}", map[string]any{"slug": frontmatter.Slug})
+			if err == nil || !errors.Is(err, sql.ErrNoRows) {
+				return post, err
+			}
 		}
-		post = core.NewRecord(collection)
 	}
 
+	collection, err := app.pb.FindCollectionByNameOrId("posts")
+	if err != nil {
+		return nil, err
+	}
+
+	post := core.NewRecord(collection)
+	if mode == ingestUpsert && frontmatter.ID != "" {
+		post.Set("id", frontmatter.ID)
+	}
+	return post, nil
+}
+
+// ingestPost saves the post and everything hanging off it using txApp
+func (app *App) ingestPost(txApp core.App, post *core.Record, frontmatter *PostFrontmatter, markdownContent string, queueType string) error {
 	var slug string
-	if !isUpdate || post.GetString("slug") == "" {
-		slug = app.generateUniqueSlug(frontmatter.Title, "posts")
+	switch {
+	case frontmatter.Slug != "" && frontmatter.Slug != post.GetString("slug"):
+		// a slug from the frontmatter is kept as is rather than numbered
+		if app.slugExists(txApp, frontmatter.Slug, "posts") {
+			return ingestFailed("post", "slug", fmt.Errorf("slug %q is used by another post", frontmatter.Slug))
+		}
+		slug = frontmatter.Slug
 		post.Set("slug", slug)
-	} else {
+	case post.IsNew() || post.GetString("slug") == "":
+		slug = app.generateUniqueSlug(txApp, frontmatter.Title, "posts")
+		post.Set("slug", slug)
+	default:
 		slug = post.GetString("slug")
 	}
 
 	if appURL := os.Getenv("APP_URL"); appURL != "" {
-		permalink := fmt.Sprintf("%s/%s", strings.TrimRight(appURL, "/"), slug)
+		permalink := fmt.Sprintf("%s/posts/%s", strings.TrimRight(appURL, "/"), slug)
 		post.Set("permalink", permalink)
 	}
 
@@ -88,57 +277,73 @@ func (app *App) processPost(re *core.RequestEvent, isUpdate bool, postID string)
 	post.Set("subtitle", frontmatter.Subtitle)
 	post.Set("content", markdownContent)
 	post.Set("type", "Blog")
-	post.Set("is_visible", frontmatter.IsVisible)
+	// validateFrontmatter has already rejected unparseable dates
+	publishAt, _ := parseScheduleTime(frontmatter.PublishAt)
+	unpublishAt, _ := parseScheduleTime(frontmatter.UnpublishAt)
+	post.Set("publish_at", publishAt)
+	post.Set("unpublish_at", unpublishAt)
+	post.Set("is_visible", scheduledVisibility(frontmatter.IsVisible, publishAt, unpublishAt, time.Now()))
 	post.Set("summary", frontmatter.Summary)
+	// an export of a trashed post keeps it in the trash, posting it again
+	// without deleted_at takes it out
+	deletedAt, _ := parseScheduleTime(frontmatter.DeletedAt)
+	post.Set("deleted_at", deletedAt)
 
-	if frontmatter.FeaturedImage != "" && len(frontmatter.FeaturedImage) == 15 && !strings.Contains(frontmatter.FeaturedImage, "/") {
+	// validateFrontmatter has already dropped unusable references
+	if frontmatter.FeaturedImage != "" {
 		post.Set("featured_image", frontmatter.FeaturedImage)
 	}
+	if len(frontmatter.FeaturedImages) > 0 {
+		post.Set("featured_images", frontmatter.FeaturedImages)
+	}
 
-	if err := app.pb.Save(post); err != nil {
-		return re.BadRequestError("Failed to save post", err)
+	if err := txApp.Save(post); err != nil {
+		return recordFailed("post", err)
 	}
 
-	// process tags
-	if err := app.processTags(post, frontmatter.Tags); err != nil {
-		log.Printf("Error processing tags: %v", err)
+	if err := app.processTags(txApp, post, frontmatter.Tags); err != nil {
+		return err
 	}
 
-	// process contexts
-	if err := app.processContexts(post, frontmatter.Contexts); err != nil {
-		log.Printf("Error processing contexts: %v", err)
+	if err := app.processContexts(txApp, post, frontmatter.Contexts); err != nil {
+		return err
 	}
 
-	// process collections
-	if err := app.processCollections(post, frontmatter.Collections); err != nil {
-		app.pb.Logger().Error("Error processing collections: %v", err)
+	if err := app.processCollections(txApp, post, frontmatter.Collections); err != nil {
+		return err
 	}
 
-	// Process chapters
-	if err := app.processChapters(post, markdownContent); err != nil {
-		app.pb.Logger().Error("Error processing chapters", err)
+	if err := app.processChapters(txApp, post, markdownContent); err != nil {
+		return err
 	}
 
-	// Process crosspost queue
-	queueType := "Create"
-	if isUpdate {
-		queueType = "Update"
+	// Render html now that the chapter slugs exist
+	if err := app.renderPost(txApp, post); err != nil {
+		return ingestFailed("render", "content", err)
 	}
-	if err := app.processCrosspostQueue(post, frontmatter, queueType); err != nil {
-		log.Printf("Error processing crosspost queue: %v", err)
+	if err := txApp.Save(post); err != nil {
+		return recordFailed("render", err)
 	}
 
-	return re.JSON(200, map[string]any{
-		"post":    post,
-		"message": "Post processed successfully",
-	})
+	if !deletedAt.IsZero() {
+		if err := app.trashPost(txApp, post); err != nil {
+			return ingestFailed("trash", "deleted_at", err)
+		}
+		return nil
+	}
+
+	return app.processCrosspostQueue(txApp, post, frontmatter, queueType)
 }
 
-func (app *App) parseFrontmatter(content string) (*PostFrontmatter, string, error) {
+// parseFrontmatter splits the post into frontmatter and markdown and
+// validates the frontmatter. the error is only set when it can't be parsed
+// at all, anything else ends up in the report
+func (app *App) parseFrontmatter(content string) (*PostFrontmatter, string, frontmatterReport, error) {
+	report := newFrontmatterReport()
 	lines := strings.Split(content, "\n")
 
 	if len(lines) < 3 || lines[0] != "---" {
-		return nil, "", fmt.Errorf("invalid frontmatter format")
+		return nil, "", report, fmt.Errorf("invalid frontmatter format")
 	}
 
 	var frontmatterLines []string
@@ -153,7 +358,7 @@ func (app *App) parseFrontmatter(content string) (*PostFrontmatter, string, erro
 	}
 
 	if contentStart == 0 {
-		return nil, "", fmt.Errorf("frontmatter not properly closed")
+		return nil, "", report, fmt.Errorf("frontmatter not properly closed")
 	}
 
 	frontmatterContent := strings.Join(frontmatterLines, "\n")
@@ -162,13 +367,25 @@ func (app *App) parseFrontmatter(content string) (*PostFrontmatter, string, erro
 
 	var frontmatter PostFrontmatter
 	if err := yaml.Unmarshal([]byte(frontmatterContent), &frontmatter); err != nil {
-		return nil, "", err
+		return nil, "", report, err
 	}
 
-	return &frontmatter, markdownContent, nil
+	// unmarshal again keeping the keys, to catch misspelled ones
+	var raw yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(frontmatterContent), &raw); err != nil {
+		return nil, "", report, err
+	}
+
+	keys := make([]string, 0, len(raw))
+	for _, item := range raw {
+		keys = append(keys, fmt.Sprint(item.Key))
+	}
+
+	report = app.validateFrontmatter(&frontmatter, keys, markdownContent)
+	return &frontmatter, markdownContent, report, nil
 }
 
-func (app *App) processTags(post *core.Record, tagNames []string) error {
+func (app *App) processTags(txApp core.App, post *core.Record, tagNames []string) error {
 	if len(tagNames) == 0 {
 		return nil
 	}
@@ -182,23 +399,23 @@ func (app *App) processTags(post *core.Record, tagNames []string) error {
 		}
 
 		// Try to find existing tag
-		tag, err := app.pb.FindFirstRecordByFilter("tags", "title = {:title}", map[string]any{"title": tagName})
+		tag, err := txApp.FindFirstRecordByFilter("tags", "title = {:title}", map[string]any{"title": tagName})
 
 		if err != nil {
 			// Tag doesn't exist, create it
-			collection, err := app.pb.FindCollectionByNameOrId("tags")
+			collection, err := txApp.FindCollectionByNameOrId("tags")
 			if err != nil {
-				log.Printf("Tags collection not found: %v", err)
-				continue
+				return ingestFailed("tags", "tags", err)
 			}
 
 			tag = core.NewRecord(collection)
 			tag.Set("title", tagName)
 			tag.Set("search_count", 0)
+			// so it's deleted again once no post uses it
+			tag.Set("auto_created", true)
 
-			if err := app.pb.Save(tag); err != nil {
-				log.Printf("Failed to create tag %s: %v", tagName, err)
-				continue
+			if err := txApp.Save(tag); err != nil {
+				return ingestFailed("tags", "tags", fmt.Errorf("failed to create tag %q: %w", tagName, err))
 			}
 		}
 
@@ -208,130 +425,169 @@ func (app *App) processTags(post *core.Record, tagNames []string) error {
 	if len(tagIDs) > 0 {
 		post.Set("tags", tagIDs)
 		// Save the post again to update the tags
-		if err := app.pb.Save(post); err != nil {
-			log.Printf("Failed to update post tags: %v", err)
+		if err := txApp.Save(post); err != nil {
+			return ingestFailed("tags", "tags", err)
 		}
 	}
 
 	return nil
 }
 
-func (app *App) processContexts(post *core.Record, contextNames []string) error {
-	if len(contextNames) == 0 {
-		return nil
-	}
-
+// processContexts replaces the post's contexts with contextNames. an empty
+// list unlinks them all
+func (app *App) processContexts(txApp core.App, post *core.Record, contextNames []string) error {
 	// First, delete existing context_posts for this post
 	if post.Id != "" {
-		existingContextPosts, _ := app.pb.FindRecordsByFilter("context_posts", "post = {:postId}", "-created", 0, 0, map[string]any{"postId": post.Id})
+		existingContextPosts, err := txApp.FindRecordsByFilter("context_posts", "post = {:postId}", "-created", 0, 0, map[string]any{"postId": post.Id})
+		if err != nil {
+			return ingestFailed("contexts", "contexts", err)
+		}
 		for _, cp := range existingContextPosts {
-			app.pb.Delete(cp)
+			if err := txApp.Delete(cp); err != nil {
+				return ingestFailed("contexts", "contexts", err)
+			}
 		}
 	}
 
+	collection, err := txApp.FindCollectionByNameOrId("context_posts")
+	if err != nil {
+		return ingestFailed("contexts", "contexts", err)
+	}
+
 	for _, contextName := range contextNames {
 		contextName = strings.TrimSpace(contextName)
 		if contextName == "" {
 			continue
 		}
 
-		// Find the context by title
-		context, err := app.pb.FindFirstRecordByFilter("contexts", "title = {:title}", map[string]any{"title": contextName})
+		// contexts are set up by hand, so an unknown one is a mistake in the post
+		context, err := txApp.FindFirstRecordByFilter("contexts", "title = {:title}", map[string]any{"title": contextName})
 		if err != nil {
-			log.Printf("Context not found: %s", contextName)
-			continue
+			return ingestFailed("contexts", "contexts", fmt.Errorf("context %q not found", contextName))
 		}
 
 		// Create context_posts junction record
-		collection, err := app.pb.FindCollectionByNameOrId("context_posts")
-		if err != nil {
-			log.Printf("Context_posts collection not found: %v", err)
-			continue
-		}
-
 		contextPost := core.NewRecord(collection)
 		contextPost.Set("context", context.Id)
 		contextPost.Set("post", post.Id)
 
-		if err := app.pb.Save(contextPost); err != nil {
-			log.Printf("Failed to create context_post for %s: %v", contextName, err)
+		if err := txApp.Save(contextPost); err != nil {
+			return ingestFailed("contexts", "contexts", fmt.Errorf("failed to link context %q: %w", contextName, err))
 		}
 	}
 
 	return nil
 }
 
-func (app *App) processCollections(post *core.Record, collectionNames []string) error {
-	if len(collectionNames) == 0 {
-		return nil
-	}
-
-	// delete existing connections
+// processCollections links the post to collectionNames and unlinks it from
+// the rest, so an empty list unlinks them all
+func (app *App) processCollections(txApp core.App, post *core.Record, collectionNames []string) error {
+	// existing memberships keep their place in the series
+	existing := make(map[string]*core.Record)
 	if post.Id != "" {
-		existingCollectionPosts, _ := app.pb.FindRecordsByFilter("collection_posts", "post = {:postId}", "-created", 0, 0, map[string]any{"postId": post.Id})
+		existingCollectionPosts, err := txApp.FindRecordsByFilter("collection_posts", "post = {:postId}", "-created", 0, 0, map[string]any{"postId": post.Id})
+		if err != nil {
+			return ingestFailed("collections", "collections", err)
+		}
 		for _, cp := range existingCollectionPosts {
-			app.pb.Delete(cp)
+			existing[cp.GetString("collection")] = cp
 		}
 	}
+	listed := make(map[string]bool)
+
+	collectionPostsCollection, err := txApp.FindCollectionByNameOrId("collection_posts")
+	if err != nil {
+		return ingestFailed("collections", "collections", err)
+	}
 
-	for order, collectionName := range collectionNames {
+	for _, collectionName := range collectionNames {
 		collectionName = strings.TrimSpace(collectionName)
 		if collectionName == "" {
 			continue
 		}
 
 		// find or create the collection by title
-		collection, err := app.pb.FindFirstRecordByFilter("collections", "title = {:title}", map[string]any{"title": collectionName})
+		collection, err := txApp.FindFirstRecordByFilter("collections", "title = {:title}", map[string]any{"title": collectionName})
 		if err != nil {
 			// collection doesn't exist, create it
 			log.Printf("Collection '%s' not found, creating it", collectionName)
 
-			collectionsCollection, err := app.pb.FindCollectionByNameOrId("collections")
+			collectionsCollection, err := txApp.FindCollectionByNameOrId("collections")
 			if err != nil {
-				log.Printf("Collections collection not found: %v", err)
-				continue
+				return ingestFailed("collections", "collections", err)
 			}
 
 			collection = core.NewRecord(collectionsCollection)
 			collection.Set("title", collectionName)
-			collection.Set("slug", app.generateUniqueSlug(collectionName, "collections"))
+			collection.Set("slug", app.generateUniqueSlug(txApp, collectionName, "collections"))
 			collection.Set("description", "")
 
-			if err := app.pb.Save(collection); err != nil {
-				log.Printf("Failed to create collection %s: %v", collectionName, err)
-				continue
+			if err := txApp.Save(collection); err != nil {
+				return ingestFailed("collections", "collections", fmt.Errorf("failed to create collection %q: %w", collectionName, err))
 			}
 			log.Printf("Successfully created collection: %s", collectionName)
 		}
 
-		// create collection_posts junction record
-		collectionPostsCollection, err := app.pb.FindCollectionByNameOrId("collection_posts")
-		if err != nil {
-			log.Printf("Collection_posts collection not found: %v", err)
+		listed[collection.Id] = true
+		if _, ok := existing[collection.Id]; ok {
 			continue
 		}
 
+		// new members go on the end of the series
+		order, err := app.nextCollectionOrder(txApp, collection.Id)
+		if err != nil {
+			return ingestFailed("collections", "collections", err)
+		}
+
+		// create collection_posts junction record
 		collectionPost := core.NewRecord(collectionPostsCollection)
 		collectionPost.Set("collection", collection.Id)
 		collectionPost.Set("post", post.Id)
 		collectionPost.Set("order", order)
 
-		if err := app.pb.Save(collectionPost); err != nil {
-			log.Printf("Failed to create collection_post for %s: %v", collectionName, err)
-		} else {
-			log.Printf("Successfully linked post to collection: %s", collectionName)
+		if err := txApp.Save(collectionPost); err != nil {
+			return ingestFailed("collections", "collections", fmt.Errorf("failed to link collection %q: %w", collectionName, err))
+		}
+		log.Printf("Successfully linked post to collection: %s", collectionName)
+	}
+
+	// unlink collections that were removed from the frontmatter
+	for collectionID, cp := range existing {
+		if listed[collectionID] {
+			continue
+		}
+		if err := txApp.Delete(cp); err != nil {
+			return ingestFailed("collections", "collections", err)
 		}
 	}
 
 	return nil
 }
 
-func (app *App) processChapters(post *core.Record, markdownContent string) error {
+// nextCollectionOrder is the order for a post appended to a collection.
+// orders start at 1 since the field is required and 0 counts as blank
+func (app *App) nextCollectionOrder(txApp core.App, collectionID string) (int, error) {
+	last, err := txApp.FindRecordsByFilter("collection_posts", "collection = {:collectionId}", "-order", 1, 0, map[string]any{"collectionId": collectionID})
+	if err != nil {
+		return 0, err
+	}
+	if len(last) == 0 {
+		return 1, nil
+	}
+	return last[0].GetInt("order") + 1, nil
+}
+
+func (app *App) processChapters(txApp core.App, post *core.Record, markdownContent string) error {
 	//  delete existing chapters for this post
 	if post.Id != "" {
-		existingChapters, _ := app.pb.FindRecordsByFilter("post_chapters", "post = {:postId}", "-created", 0, 0, map[string]any{"postId": post.Id})
+		existingChapters, err := txApp.FindRecordsByFilter("post_chapters", "post = {:postId}", "-created", 0, 0, map[string]any{"postId": post.Id})
+		if err != nil {
+			return ingestFailed("chapters", "content", err)
+		}
 		for _, chapter := range existingChapters {
-			app.pb.Delete(chapter)
+			if err := txApp.Delete(chapter); err != nil {
+				return ingestFailed("chapters", "content", err)
+			}
 		}
 	}
 
@@ -343,15 +599,15 @@ func (app *App) processChapters(post *core.Record, markdownContent string) error
 
 	chapterRecords := make(map[string]*core.Record)
 
-	collection, err := app.pb.FindCollectionByNameOrId("post_chapters")
+	collection, err := txApp.FindCollectionByNameOrId("post_chapters")
 	if err != nil {
-		return fmt.Errorf("post_chapters collection not found: %v", err)
+		return ingestFailed("chapters", "content", fmt.Errorf("post_chapters collection not found: %w", err))
 	}
 
 	for i, chapter := range chapters {
 		app.pb.Logger().Info(chapter.Title)
 		chapterRecord := core.NewRecord(collection)
-		chapterSlug := app.generateUniqueSlug(chapter.Title, "post_chapters")
+		chapterSlug := app.generateUniqueSlug(txApp, chapter.Title, "post_chapters")
 
 		chapterRecord.Set("post", post.Id)
 		chapterRecord.Set("title", chapter.Title)
@@ -369,9 +625,8 @@ func (app *App) processChapters(post *core.Record, markdownContent string) error
 			}
 		}
 
-		if err := app.pb.Save(chapterRecord); err != nil {
-			app.pb.Logger().Error("Failed to create chapter", chapter.Title, err)
-			continue
+		if err := txApp.Save(chapterRecord); err != nil {
+			return ingestFailed("chapters", "content", fmt.Errorf("failed to create chapter %q: %w", chapter.Title, err))
 		}
 
 		chapterRecords[chapterSlug] = chapterRecord
@@ -434,40 +689,79 @@ func (app *App) parseChapters(content string) []Chapter {
 	return chapters
 }
 
-func (app *App) processCrosspostQueue(post *core.Record, frontmatter *PostFrontmatter, queueType string) error {
-	if !frontmatter.CrosspostInstagram && !frontmatter.CrosspostThreads {
-		return nil
-	}
+// processCrosspostQueue queues a job for every platform in the registry the
+// frontmatter asks for. platforms without an account are skipped, which
+// validateFrontmatter warns about. a post has at most one pending job per
+// platform, as jobs publish the post as it is when they run
+func (app *App) processCrosspostQueue(txApp core.App, post *core.Record, frontmatter *PostFrontmatter, queueType string) error {
+	for _, name := range crosspostPlatformNames() {
+		platform := crosspostPlatforms[name]
+		if !platform.Enabled(frontmatter) {
+			continue
+		}
 
-	if frontmatter.CrosspostInstagram {
-		instagramAccounts, err := app.pb.FindRecordsByFilter("instagram_accounts", "", "-created", 1, 0)
-		if err == nil && len(instagramAccounts) > 0 {
-			collection, err := app.pb.FindCollectionByNameOrId("crosspost_queue")
-			if err != nil {
-				log.Printf("Crosspost_queue collection not found: %v", err)
-				return nil
-			}
+		account, err := platform.Account(txApp)
+		if err != nil {
+			return ingestFailed("crosspost", platform.Flag, err)
+		}
+		if account == nil {
+			continue
+		}
 
-			queueRecord := core.NewRecord(collection)
-			queueRecord.Set("platform", "Instagram")
-			queueRecord.Set("type", queueType)
-			queueRecord.Set("post", post.Id)
-			queueRecord.Set("status", "Queued")
-			queueRecord.Set("instagram_account", instagramAccounts[0].Id)
+		// posts waiting on publish_at get their jobs queued by
+		// publishScheduledPosts
+		status := "Queued"
+		if isScheduled(post) {
+			status = "Scheduled"
+		}
 
-			if err := app.pb.Save(queueRecord); err != nil {
-				log.Printf("Failed to create Instagram crosspost queue: %v", err)
+		pending, err := txApp.FindRecordsByFilter(
+			"crosspost_queue",
+			"post = {:postId} && platform = {:platform} && type != 'Delete' && (status = 'Queued' || status = 'Scheduled' || status = 'Failure')",
+			"-created",
+			1,
+			0,
+			map[string]any{"postId": post.Id, "platform": name},
+		)
+		if err != nil {
+			return ingestFailed("crosspost", platform.Flag, err)
+		}
+		if len(pending) > 0 {
+			// a failed job keeps its backoff, a waiting one follows the schedule
+			job := pending[0]
+			if job.GetString("status") != "Failure" && job.GetString("status") != status {
+				job.Set("status", status)
+				if err := txApp.Save(job); err != nil {
+					return ingestFailed("crosspost", platform.Flag, err)
+				}
 			}
+			continue
+		}
+
+		collection, err := txApp.FindCollectionByNameOrId("crosspost_queue")
+		if err != nil {
+			return ingestFailed("crosspost", platform.Flag, err)
+		}
+
+		queueRecord := core.NewRecord(collection)
+		queueRecord.Set("platform", name)
+		queueRecord.Set("type", queueType)
+		queueRecord.Set("post", post.Id)
+		queueRecord.Set("status", status)
+		queueRecord.Set("instagram_account", account.Id)
+
+		if err := txApp.Save(queueRecord); err != nil {
+			return ingestFailed("crosspost", platform.Flag, err)
 		}
 	}
 
 	return nil
 }
 
-func (app *App) generateUniqueSlug(title, collectionName string) string {
+func (app *App) generateUniqueSlug(txApp core.App, title, collectionName string) string {
 	baseSlug := app.generateSlugBase(title)
 
-	if !app.slugExists(baseSlug, collectionName) {
+	if !app.slugExists(txApp, baseSlug, collectionName) {
 		return baseSlug
 	}
 
@@ -475,7 +769,7 @@ func (app *App) generateUniqueSlug(title, collectionName string) string {
 	counter := 1
 	for {
 		candidateSlug := fmt.Sprintf("%s-%d", baseSlug, counter)
-		if !app.slugExists(candidateSlug, collectionName) {
+		if !app.slugExists(txApp, candidateSlug, collectionName) {
 			return candidateSlug
 		}
 		counter++
@@ -500,14 +794,14 @@ func (app *App) generateSlugBase(title string) string {
 	return slug
 }
 
-func (app *App) slugExists(slug, collectionName string) bool {
+func (app *App) slugExists(txApp core.App, slug, collectionName string) bool {
 	var filter string
 	var params map[string]any
 
 	if collectionName == "posts" {
 		// for posts, also check permalink conflicts if app_url is set
 		if appURL := os.Getenv("APP_URL"); appURL != "" {
-			expectedPermalink := fmt.Sprintf("%s/%s", strings.TrimRight(appURL, "/"), slug)
+			expectedPermalink := fmt.Sprintf("%s/posts/%s", strings.TrimRight(appURL, "/"), slug)
 			filter = "slug = {:slug} || permalink = {:permalink}"
 			params = map[string]any{
 				"slug":      slug,
@@ -522,6 +816,6 @@ func (app *App) slugExists(slug, collectionName string) bool {
 		params = map[string]any{"slug": slug}
 	}
 
-	record, err := app.pb.FindFirstRecordByFilter(collectionName, filter, params)
+	record, err := txApp.FindFirstRecordByFilter(collectionName, filter, params)
 	return err == nil && record != nil
 }
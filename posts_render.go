@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const wordsPerMinute = 200
+
+var plainTextPolicy = bluemonday.StrictPolicy()
+
+// renderPost fills content_html, word_count and reading_time from the post's
+// markdown and its saved chapters. the caller is responsible for saving.
+// txApp is used to read the chapters so uncommitted ones are seen
+func (app *App) renderPost(txApp core.App, post *core.Record) error {
+	chapters, err := txApp.FindRecordsByFilter("post_chapters", "post = {:postId}", "order", 0, 0, map[string]any{"postId": post.Id})
+	if err != nil {
+		return fmt.Errorf("failed to load chapters: %v", err)
+	}
+
+	contentHTML, err := app.renderMarkdown(post.GetString("content"), chapters)
+	if err != nil {
+		return err
+	}
+
+	words := len(strings.Fields(html.UnescapeString(plainTextPolicy.Sanitize(contentHTML))))
+
+	post.Set("content_html", contentHTML)
+	post.Set("word_count", words)
+	post.Set("reading_time", readingTime(words))
+	return nil
+}
+
+// readingTime returns the estimated minutes to read, rounded up
+func readingTime(words int) int {
+	if words == 0 {
+		return 0
+	}
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// renderPostHook keeps chapters and the stored html in sync when a post is
+// created or edited through the admin ui / records api
+func (app *App) renderPostHook(e *core.RecordRequestEvent) error {
+	contentChanged := e.Record.Original().GetString("content") != e.Record.GetString("content")
+
+	if err := e.Next(); err != nil {
+		return err
+	}
+
+	if !contentChanged {
+		return nil
+	}
+
+	// the record is already saved at this point, so failures are only logged
+	if err := app.processChapters(e.App, e.Record, e.Record.GetString("content")); err != nil {
+		app.pb.Logger().Error("Error processing chapters", "post_id", e.Record.Id, "error", err)
+	}
+
+	if err := app.renderPost(e.App, e.Record); err != nil {
+		app.pb.Logger().Error("Failed to render post", "post_id", e.Record.Id, "error", err)
+		return nil
+	}
+
+	if err := e.App.Save(e.Record); err != nil {
+		app.pb.Logger().Error("Failed to save rendered post", "post_id", e.Record.Id, "error", err)
+	}
+
+	return nil
+}
+
+// rerenderPosts rebuilds the stored html for every post, used after the
+// renderer changes. chapters are left alone so existing anchors keep working
+func (app *App) rerenderPosts() error {
+	posts, err := app.pb.FindAllRecords("posts")
+	if err != nil {
+		return fmt.Errorf("failed to fetch posts: %v", err)
+	}
+
+	var failed int
+	for _, post := range posts {
+		if err := app.renderPost(app.pb, post); err != nil {
+			log.Printf("Failed to render post %s: %v", post.Id, err)
+			failed++
+			continue
+		}
+
+		if err := app.pb.Save(post); err != nil {
+			log.Printf("Failed to save post %s: %v", post.Id, err)
+			failed++
+		}
+	}
+
+	log.Printf("Rendered %d of %d posts", len(posts)-failed, len(posts))
+	if failed > 0 {
+		return fmt.Errorf("%d posts failed to render", failed)
+	}
+	return nil
+}
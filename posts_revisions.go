@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type revisionSummary struct {
+	ID      string `json:"id"`
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	Created string `json:"created"`
+}
+
+// saveRevision snapshots the markdown a post was ingested from, skipping it
+// when nothing changed since the last one. revisions are numbered rather than
+// ordered by created, which two saves in one request can share
+func (app *App) saveRevision(txApp core.App, post *core.Record, markdown string) error {
+	latest, err := txApp.FindRecordsByFilter("post_revisions", "post = {:postId}", "-number", 1, 0, map[string]any{"postId": post.Id})
+	if err != nil {
+		return ingestFailed("revision", "", err)
+	}
+
+	number := 1
+	if len(latest) > 0 {
+		if latest[0].GetString("markdown") == markdown {
+			return nil
+		}
+		number = latest[0].GetInt("number") + 1
+	}
+
+	collection, err := txApp.FindCollectionByNameOrId("post_revisions")
+	if err != nil {
+		return ingestFailed("revision", "", err)
+	}
+
+	revision := core.NewRecord(collection)
+	revision.Set("post", post.Id)
+	revision.Set("number", number)
+	revision.Set("title", post.GetString("title"))
+	revision.Set("markdown", markdown)
+
+	if err := txApp.Save(revision); err != nil {
+		return recordFailed("revision", err)
+	}
+	return nil
+}
+
+// snapshotPost saves the post as it is before an update when it has no
+// revisions yet, so posts from before revisions existed keep their first
+// version
+func (app *App) snapshotPost(txApp core.App, post *core.Record) error {
+	count, err := txApp.CountRecords("post_revisions", dbx.HashExp{"post": post.Id})
+	if err != nil {
+		return ingestFailed("revision", "", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	markdown, err := app.postMarkdown(txApp, post)
+	if err != nil {
+		return ingestFailed("revision", "", err)
+	}
+	return app.saveRevision(txApp, post, markdown)
+}
+
+// revisionPostHook keeps edits made in the admin ui in the history, as the
+// post's exported markdown
+func (app *App) revisionPostHook(e *core.RecordRequestEvent) error {
+	if err := app.snapshotPost(e.App, e.Record.Original()); err != nil {
+		app.pb.Logger().Error("Failed to snapshot post", "post_id", e.Record.Id, "error", err)
+	}
+
+	if err := e.Next(); err != nil {
+		return err
+	}
+
+	markdown, err := app.postMarkdown(e.App, e.Record)
+	if err == nil {
+		err = app.saveRevision(e.App, e.Record, markdown)
+	}
+	if err != nil {
+		app.pb.Logger().Error("Failed to save post revision", "post_id", e.Record.Id, "error", err)
+	}
+	return nil
+}
+
+// findPostRevisions returns a post's revisions, oldest first
+func (app *App) findPostRevisions(postID string) ([]*core.Record, error) {
+	return app.pb.FindRecordsByFilter("post_revisions", "post = {:postId}", "number", 0, 0, map[string]any{"postId": postID})
+}
+
+// findPostByIDOrSlug lets api routes take whichever one the caller has
+func (app *App) findPostByIDOrSlug(value string) (*core.Record, error) {
+	return app.pb.FindFirstRecordByFilter("posts", "id = {:value} || slug = {:value}", map[string]any{"value": value})
+}
+
+// postRevisionsList lists a post's revisions, oldest first
+func (app *App) postRevisionsList(re *core.RequestEvent) error {
+	post, err := app.findPostByIDOrSlug(re.Request.PathValue("id"))
+	if err != nil {
+		return re.NotFoundError("Post not found", err)
+	}
+
+	revisions, err := app.findPostRevisions(post.Id)
+	if err != nil {
+		return re.InternalServerError("Failed to load revisions", err)
+	}
+
+	summaries := make([]revisionSummary, 0, len(revisions))
+	for _, revision := range revisions {
+		summaries = append(summaries, revisionSummary{
+			ID:      revision.Id,
+			Number:  revision.GetInt("number"),
+			Title:   revision.GetString("title"),
+			Created: revision.GetString("created"),
+		})
+	}
+
+	return re.JSON(http.StatusOK, map[string]any{
+		"post": map[string]any{
+			"id":    post.Id,
+			"slug":  post.GetString("slug"),
+			"title": post.GetString("title"),
+		},
+		"revisions": summaries,
+	})
+}
+
+func (app *App) revisionView(re *core.RequestEvent) error {
+	revision, err := app.pb.FindRecordById("post_revisions", re.Request.PathValue("id"))
+	if err != nil {
+		return re.NotFoundError("Revision not found", err)
+	}
+
+	return re.JSON(http.StatusOK, revision)
+}
+
+// postRevisionsDiff returns a unified diff between two revisions, given as
+// numbers or ids. to defaults to the latest and from to the one before it
+func (app *App) postRevisionsDiff(re *core.RequestEvent) error {
+	post, err := app.findPostByIDOrSlug(re.Request.PathValue("id"))
+	if err != nil {
+		return re.NotFoundError("Post not found", err)
+	}
+
+	revisions, err := app.findPostRevisions(post.Id)
+	if err != nil {
+		return re.InternalServerError("Failed to load revisions", err)
+	}
+	if len(revisions) == 0 {
+		return re.NotFoundError("Post has no revisions", nil)
+	}
+
+	query := re.Request.URL.Query()
+
+	to := len(revisions) - 1
+	if value := query.Get("to"); value != "" {
+		if to, err = revisionIndex(revisions, value); err != nil {
+			return re.BadRequestError("Invalid to revision", err)
+		}
+	}
+
+	from := to - 1
+	if value := query.Get("from"); value != "" {
+		if from, err = revisionIndex(revisions, value); err != nil {
+			return re.BadRequestError("Invalid from revision", err)
+		}
+	}
+
+	// diffing the first revision compares it against nothing
+	var fromLines []string
+	fromName := "/dev/null"
+	if from >= 0 {
+		fromLines = difflib.SplitLines(revisions[from].GetString("markdown"))
+		fromName = fmt.Sprintf("%s@%d", post.GetString("slug"), revisions[from].GetInt("number"))
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        fromLines,
+		B:        difflib.SplitLines(revisions[to].GetString("markdown")),
+		FromFile: fromName,
+		ToFile:   fmt.Sprintf("%s@%d", post.GetString("slug"), revisions[to].GetInt("number")),
+		Context:  3,
+	})
+	if err != nil {
+		return re.InternalServerError("Failed to diff revisions", err)
+	}
+
+	return re.String(http.StatusOK, diff)
+}
+
+// restoreRevision puts a revision back by ingesting its markdown again,
+// which records it as a new revision
+func (app *App) restoreRevision(re *core.RequestEvent) error {
+	revision, err := app.pb.FindRecordById("post_revisions", re.Request.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return re.NotFoundError("Revision not found", err)
+		}
+		return re.InternalServerError("Failed to load revision", err)
+	}
+
+	return app.ingestMarkdown(re, ingestUpdate, revision.GetString("post"), revision.GetString("markdown"))
+}
+
+// revisionIndex resolves a revision number or a revision id to its place in
+// revisions
+func revisionIndex(revisions []*core.Record, value string) (int, error) {
+	number, numberErr := strconv.Atoi(value)
+
+	for i, revision := range revisions {
+		if revision.Id == value || (numberErr == nil && revision.GetInt("number") == number) {
+			return i, nil
+		}
+	}
+
+	if numberErr == nil {
+		return 0, fmt.Errorf("revision %d doesn't exist", number)
+	}
+	return 0, fmt.Errorf("revision %q doesn't belong to this post", value)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// layouts accepted for publish_at / unpublish_at. times without a zone are
+// taken as utc
+var scheduleLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// sqlNow matches the format pocketbase stores dates in
+const sqlNow = "strftime('%Y-%m-%d %H:%M:%fZ', 'now')"
+
+// visibleFilter matches posts that should be live right now. prefix is the
+// relation path to the post, e.g. "post." from a junction collection.
+// a due publish_at counts even if publishScheduledPosts hasn't flipped
+// is_visible yet, so hiding a published post means clearing its publish_at,
+// which hidePostHook and unpublishPost do. posts in the trash are never
+// visible
+func visibleFilter(prefix string) string {
+	return fmt.Sprintf(
+		"%[1]sdeleted_at = '' && (%[1]sis_visible = true || %[1]spublish_at != '') && (%[1]spublish_at = '' || %[1]spublish_at <= @now) && (%[1]sunpublish_at = '' || %[1]sunpublish_at > @now)",
+		prefix,
+	)
+}
+
+// visibleSQL is visibleFilter for raw queries, table being the posts alias
+func visibleSQL(table string) string {
+	return fmt.Sprintf(
+		"%[1]s.deleted_at = '' AND (%[1]s.is_visible = TRUE OR %[1]s.publish_at != '') AND (%[1]s.publish_at = '' OR %[1]s.publish_at <= %[2]s) AND (%[1]s.unpublish_at = '' OR %[1]s.unpublish_at > %[2]s)",
+		table,
+		sqlNow,
+	)
+}
+
+func parseScheduleTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range scheduleLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a date, use e.g. 2006-01-02 15:04 or RFC 3339", value)
+}
+
+// scheduledVisibility is is_visible for a post at now. without a schedule
+// the frontmatter decides
+func scheduledVisibility(isVisible bool, publishAt, unpublishAt, now time.Time) bool {
+	if !unpublishAt.IsZero() && !now.Before(unpublishAt) {
+		return false
+	}
+	if !publishAt.IsZero() {
+		return !now.Before(publishAt)
+	}
+	return isVisible
+}
+
+// hidePostHook clears a due publish_at when a post is hidden in the admin
+// ui, which visibleFilter would otherwise keep showing
+func (app *App) hidePostHook(e *core.RecordRequestEvent) error {
+	if e.Record.Original().GetBool("is_visible") && !e.Record.GetBool("is_visible") {
+		publishAt := e.Record.GetDateTime("publish_at")
+		if !publishAt.IsZero() && !publishAt.Time().After(time.Now()) {
+			e.Record.Set("publish_at", "")
+		}
+	}
+	return e.Next()
+}
+
+// isScheduled reports whether the post is waiting on its publish_at
+func isScheduled(post *core.Record) bool {
+	publishAt := post.GetDateTime("publish_at")
+	return !publishAt.IsZero() && time.Now().Before(publishAt.Time())
+}
+
+// publishScheduledPosts is run by cron to flip is_visible as posts reach
+// their publish_at / unpublish_at, queueing their crossposts on publish
+func (app *App) publishScheduledPosts() {
+	due, err := app.pb.FindRecordsByFilter(
+		"posts",
+		"deleted_at = '' && is_visible = false && publish_at != '' && publish_at <= @now && (unpublish_at = '' || unpublish_at > @now)",
+		"publish_at",
+		0,
+		0,
+	)
+	if err != nil {
+		app.pb.Logger().Error("Failed to find scheduled posts", "error", err)
+	}
+
+	for _, post := range due {
+		err := app.pb.RunInTransaction(func(txApp core.App) error {
+			post.Set("is_visible", true)
+			if err := txApp.Save(post); err != nil {
+				return err
+			}
+
+			_, err := txApp.DB().Update(
+				"crosspost_queue",
+				dbx.Params{"status": "Queued"},
+				dbx.HashExp{"post": post.Id, "status": "Scheduled"},
+			).Execute()
+			return err
+		})
+		if err != nil {
+			app.pb.Logger().Error("Failed to publish scheduled post", "post_id", post.Id, "error", err)
+			continue
+		}
+		app.pb.Logger().Info("Published scheduled post", "post_id", post.Id)
+	}
+
+	expired, err := app.pb.FindRecordsByFilter(
+		"posts",
+		"is_visible = true && unpublish_at != '' && unpublish_at <= @now",
+		"unpublish_at",
+		0,
+		0,
+	)
+	if err != nil {
+		app.pb.Logger().Error("Failed to find expired posts", "error", err)
+	}
+
+	for _, post := range expired {
+		post.Set("is_visible", false)
+		if err := app.pb.Save(post); err != nil {
+			app.pb.Logger().Error("Failed to unpublish post", "post_id", post.Id, "error", err)
+			continue
+		}
+		app.pb.Logger().Info("Unpublished post", "post_id", post.Id)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+)
+
+var (
+	recordIDRegex  = regexp.MustCompile(`^[a-z0-9]{15}$`)
+	uploadRefRegex = regexp.MustCompile(`/api/files/uploads/([a-z0-9]{15})/`)
+)
+
+// frontmatterKeys are the yaml keys PostFrontmatter understands
+var frontmatterKeys = yamlKeys(PostFrontmatter{})
+
+// frontmatterIssue is a single validation finding for a frontmatter key
+type frontmatterIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// frontmatterReport collects what validateFrontmatter found. errors stop the
+// ingest, warnings are passed back to the client with the saved post
+type frontmatterReport struct {
+	Warnings []frontmatterIssue `json:"warnings"`
+	Errors   []frontmatterIssue `json:"errors"`
+}
+
+func newFrontmatterReport() frontmatterReport {
+	return frontmatterReport{
+		Warnings: []frontmatterIssue{},
+		Errors:   []frontmatterIssue{},
+	}
+}
+
+func (r *frontmatterReport) warn(field, format string, args ...any) {
+	r.Warnings = append(r.Warnings, frontmatterIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *frontmatterReport) fail(field, format string, args ...any) {
+	r.Errors = append(r.Errors, frontmatterIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateFrontmatter checks the parsed frontmatter against the db. keys are
+// the raw yaml keys in file order. featured images that can't be used, and
+// a publish_at that would show a hidden post, are dropped so the ingest
+// skips them, matching the warning
+func (app *App) validateFrontmatter(frontmatter *PostFrontmatter, keys []string, markdownContent string) frontmatterReport {
+	report := newFrontmatterReport()
+
+	for _, key := range keys {
+		if !frontmatterKeys[key] {
+			report.warn(key, "unknown key %q is ignored", key)
+		}
+	}
+
+	if strings.TrimSpace(frontmatter.Title) == "" {
+		report.fail("title", "title is required")
+	}
+
+	if frontmatter.ID != "" && !recordIDRegex.MatchString(frontmatter.ID) {
+		report.fail("id", "%q isn't a valid post id", frontmatter.ID)
+	}
+
+	if frontmatter.Slug != "" && app.generateSlugBase(frontmatter.Slug) != frontmatter.Slug {
+		report.fail("slug", "%q isn't a valid slug, try %q", frontmatter.Slug, app.generateSlugBase(frontmatter.Slug))
+	}
+
+	app.validateContexts(&report, frontmatter.Contexts)
+
+	publishAt, err := parseScheduleTime(frontmatter.PublishAt)
+	if err != nil {
+		report.fail("publish_at", "%v", err)
+	}
+	unpublishAt, err := parseScheduleTime(frontmatter.UnpublishAt)
+	if err != nil {
+		report.fail("unpublish_at", "%v", err)
+	}
+	if !publishAt.IsZero() && !unpublishAt.IsZero() && !unpublishAt.After(publishAt) {
+		report.fail("unpublish_at", "unpublish_at has to be after publish_at")
+	}
+	if _, err := parseScheduleTime(frontmatter.DeletedAt); err != nil {
+		report.fail("deleted_at", "%v", err)
+	}
+	if !publishAt.IsZero() && frontmatter.IsVisible {
+		report.warn("is_visible", "is_visible is ignored when publish_at is set")
+	}
+	// hiding a post whose publish_at has passed drops the schedule, as
+	// hidePostHook does in the admin. exports leave is_visible out next to
+	// publish_at, so only an explicit false counts
+	if !publishAt.IsZero() && !publishAt.After(time.Now()) && !frontmatter.IsVisible && slices.Contains(keys, "is_visible") {
+		report.warn("publish_at", "publish_at has passed and is dropped, since is_visible is false")
+		frontmatter.PublishAt = ""
+	}
+
+	if image := frontmatter.FeaturedImage; image != "" {
+		if !recordIDRegex.MatchString(image) {
+			report.warn("featured_image", "%q isn't an upload id and is ignored", image)
+			frontmatter.FeaturedImage = ""
+		} else if _, err := app.pb.FindRecordById("uploads", image); err != nil {
+			report.warn("featured_image", "upload %q not found and is ignored", image)
+			frontmatter.FeaturedImage = ""
+		}
+	}
+
+	images := frontmatter.FeaturedImages[:0]
+	for _, image := range frontmatter.FeaturedImages {
+		if !recordIDRegex.MatchString(image) {
+			report.warn("featured_images", "%q isn't an upload id and is ignored", image)
+		} else if _, err := app.pb.FindRecordById("uploads", image); err != nil {
+			report.warn("featured_images", "upload %q not found and is ignored", image)
+		} else {
+			images = append(images, image)
+		}
+	}
+	frontmatter.FeaturedImages = images
+
+	app.validateUploadRefs(&report, markdownContent)
+	app.validateCrosspostAccounts(&report, frontmatter)
+
+	return report
+}
+
+// validateCrosspostAccounts warns about crosspost flags for platforms
+// without an account, since those are skipped
+func (app *App) validateCrosspostAccounts(report *frontmatterReport, frontmatter *PostFrontmatter) {
+	for _, name := range crosspostPlatformNames() {
+		platform := crosspostPlatforms[name]
+		if !platform.Enabled(frontmatter) {
+			continue
+		}
+
+		account, err := platform.Account(app.pb)
+		if err != nil {
+			report.warn(platform.Flag, "failed to look up the %s account: %v", name, err)
+			continue
+		}
+		if account == nil {
+			report.warn(platform.Flag, "%s", platform.MissingAccount)
+		}
+	}
+}
+
+// validateContexts fails on context titles that don't exist, since contexts
+// are only created by hand
+func (app *App) validateContexts(report *frontmatterReport, titles []string) {
+	var values []any
+	for _, title := range titles {
+		if title = strings.TrimSpace(title); title != "" {
+			values = append(values, title)
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	contexts, err := app.pb.FindAllRecords("contexts", dbx.In("title", values...))
+	if err != nil {
+		report.fail("contexts", "failed to look up contexts: %v", err)
+		return
+	}
+
+	found := make(map[string]bool, len(contexts))
+	for _, context := range contexts {
+		found[context.GetString("title")] = true
+	}
+
+	for _, title := range values {
+		if !found[title.(string)] {
+			report.fail("contexts", "unknown context %q", title)
+		}
+	}
+}
+
+// validateUploadRefs warns about images in the content pointing at uploads
+// that don't exist
+func (app *App) validateUploadRefs(report *frontmatterReport, markdownContent string) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, match := range uploadRefRegex.FindAllStringSubmatch(markdownContent, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			ids = append(ids, match[1])
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	uploads, err := app.pb.FindRecordsByIds("uploads", ids)
+	if err != nil {
+		report.warn("content", "failed to check image uploads: %v", err)
+		return
+	}
+
+	found := make(map[string]bool, len(uploads))
+	for _, upload := range uploads {
+		found[upload.Id] = true
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			report.warn("content", "image upload %q not found", id)
+		}
+	}
+}
+
+// yamlKeys lists the yaml tag names of a struct's fields
+func yamlKeys(v any) map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	instagramGraphURL      = "https://graph.instagram.com/v21.0"
+	instagramCaptionLimit  = 2200
+	instagramHashtagLimit  = 30
+	instagramCarouselLimit = 10
+)
+
+// instagramPublisher posts a post's images to instagram, as a single image
+// or a carousel, with the summary and tags as the caption. instagram fetches
+// the images itself, so they're linked under siteURL
+type instagramPublisher struct {
+	app     core.App
+	graph   *graphClient
+	siteURL string
+}
+
+func newInstagramPublisher(app core.App, baseURL, siteURL string) *instagramPublisher {
+	if baseURL == "" {
+		baseURL = instagramGraphURL
+	}
+	return &instagramPublisher{app: app, graph: newGraphClient(baseURL), siteURL: siteURL}
+}
+
+func (p *instagramPublisher) Create(ctx context.Context, post, account *core.Record) (string, string, error) {
+	if account == nil || account.GetString("access_key") == "" {
+		return "", "", permanent(errors.New("the account has no access key"))
+	}
+	token := account.GetString("access_key")
+
+	if errs := p.app.ExpandRecord(post, []string{"tags"}, nil); len(errs) > 0 {
+		return "", "", fmt.Errorf("failed to expand tags: %v", errs)
+	}
+
+	images := p.postImages(post)
+	if len(images) == 0 {
+		return "", "", permanent(errors.New("the post has no jpeg images to publish"))
+	}
+
+	var user struct {
+		UserID string `json:"user_id"`
+	}
+	if err := p.graph.do(ctx, http.MethodGet, "me", token, url.Values{"fields": {"user_id"}}, &user); err != nil {
+		return "", "", err
+	}
+	if user.UserID == "" {
+		return "", "", permanent(errors.New("graph api didn't return the instagram user id"))
+	}
+
+	caption := instagramCaption(post)
+
+	var creationID string
+	var err error
+	if len(images) == 1 {
+		creationID, err = p.createContainer(ctx, user.UserID, token, url.Values{
+			"image_url": {images[0]},
+			"caption":   {caption},
+		})
+	} else {
+		children := make([]string, 0, len(images))
+		for _, image := range images {
+			child, err := p.createContainer(ctx, user.UserID, token, url.Values{
+				"image_url":        {image},
+				"is_carousel_item": {"true"},
+			})
+			if err != nil {
+				return "", "", err
+			}
+			children = append(children, child)
+		}
+
+		creationID, err = p.createContainer(ctx, user.UserID, token, url.Values{
+			"media_type": {"CAROUSEL"},
+			"children":   {strings.Join(children, ",")},
+			"caption":    {caption},
+		})
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	var published struct {
+		ID string `json:"id"`
+	}
+	err = p.graph.do(ctx, http.MethodPost, user.UserID+"/media_publish", token, url.Values{
+		"creation_id": {creationID},
+	}, &published)
+	if err != nil {
+		return "", "", err
+	}
+
+	var media struct {
+		Permalink string `json:"permalink"`
+	}
+	err = p.graph.do(ctx, http.MethodGet, published.ID, token, url.Values{
+		"fields": {"permalink"},
+	}, &media)
+	if err != nil {
+		return "", "", err
+	}
+
+	return published.ID, media.Permalink, nil
+}
+
+// Update leaves the post as it is, the graph api can't change the images
+// or caption of published media
+func (p *instagramPublisher) Update(ctx context.Context, post, account *core.Record, remoteID string) (string, string, error) {
+	return remoteID, "", nil
+}
+
+func (p *instagramPublisher) Delete(ctx context.Context, post, account *core.Record, remoteID string) (string, string, error) {
+	return "", "", permanent(errors.New("instagram media can't be deleted through the graph api"))
+}
+
+func (p *instagramPublisher) createContainer(ctx context.Context, userID, token string, params url.Values) (string, error) {
+	var container struct {
+		ID string `json:"id"`
+	}
+	if err := p.graph.do(ctx, http.MethodPost, userID+"/media", token, params, &container); err != nil {
+		return "", err
+	}
+	return container.ID, nil
+}
+
+// postImages are the public urls of the featured image, the featured_images
+// and the image uploads used in the content, in that order. instagram only
+// takes jpegs, so other formats are left out
+func (p *instagramPublisher) postImages(post *core.Record) []string {
+	ids := []string{}
+	if featured := post.GetString("featured_image"); featured != "" {
+		ids = append(ids, featured)
+	}
+	featured := post.GetStringSlice("featured_images")
+	for _, id := range featured {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	for _, match := range uploadRefRegex.FindAllStringSubmatch(post.GetString("content"), -1) {
+		if !slices.Contains(ids, match[1]) {
+			ids = append(ids, match[1])
+		}
+	}
+
+	var images []string
+	for _, id := range ids {
+		upload, err := p.app.FindRecordById("uploads", id)
+		if err != nil {
+			continue
+		}
+		// featured images are images whatever their upload type says
+		if upload.Id != post.GetString("featured_image") && !slices.Contains(featured, upload.Id) && upload.GetString("type") != "Image" {
+			continue
+		}
+
+		image := upload.GetString("url")
+		if file := upload.GetString("file"); file != "" {
+			image = fmt.Sprintf("%s/api/files/uploads/%s/%s", p.siteURL, upload.Id, file)
+		}
+		if !isJPEG(image) {
+			continue
+		}
+
+		images = append(images, image)
+		if len(images) == instagramCarouselLimit {
+			break
+		}
+	}
+
+	return images
+}
+
+func isJPEG(image string) bool {
+	parsed, err := url.Parse(image)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(parsed.Path))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+// instagramCaption is the summary, or the title without one, followed by
+// a hashtag for each tag
+func instagramCaption(post *core.Record) string {
+	text := strings.TrimSpace(post.GetString("summary"))
+	if text == "" {
+		text = strings.TrimSpace(post.GetString("title"))
+	}
+
+	var hashtags []string
+	for _, title := range postTagTitles(post) {
+		hashtag := "#" + strings.Join(searchTermRegex.FindAllString(title, -1), "")
+		if hashtag == "#" || slices.Contains(hashtags, hashtag) {
+			continue
+		}
+		hashtags = append(hashtags, hashtag)
+		if len(hashtags) == instagramHashtagLimit {
+			break
+		}
+	}
+
+	if len(hashtags) == 0 {
+		return truncateRunes(text, instagramCaptionLimit)
+	}
+
+	tagLine := strings.Join(hashtags, " ")
+	room := instagramCaptionLimit - utf8.RuneCountInString(tagLine) - 2
+	return truncateRunes(text, room) + "\n\n" + tagLine
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// instagramStub stands in for the instagram graph api, recording the media
+// containers it's asked to create
+type instagramStub struct {
+	*httptest.Server
+	containers []map[string]string
+	published  int
+}
+
+func newInstagramStub(t *testing.T) *instagramStub {
+	t.Helper()
+
+	stub := &instagramStub{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/v21.0/me":
+			w.Write([]byte(`{"user_id":"17841"}`))
+		case "/v21.0/17841/media":
+			container := map[string]string{}
+			for key := range query {
+				if key != "access_token" {
+					container[key] = query.Get(key)
+				}
+			}
+			stub.containers = append(stub.containers, container)
+			fmt.Fprintf(w, `{"id":"ct%d"}`, len(stub.containers))
+		case "/v21.0/17841/media_publish":
+			stub.published++
+			w.Write([]byte(`{"id":"m99"}`))
+		case "/v21.0/m99":
+			w.Write([]byte(`{"permalink":"https://www.instagram.com/p/m99/"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+// newTestApp is an app in a temporary data dir with the fields of uploads,
+// tags and posts the publishers read, and without the hooks
+func newTestApp(t *testing.T) core.App {
+	t.Helper()
+
+	app := core.NewBaseApp(core.BaseAppConfig{DataDir: t.TempDir()})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.ResetBootstrapState() })
+
+	uploads := core.NewBaseCollection("uploads")
+	uploads.Fields.Add(
+		&core.FileField{Name: "file", MaxSelect: 1},
+		&core.URLField{Name: "url"},
+		&core.SelectField{Name: "type", MaxSelect: 1, Values: []string{"Markdown", "Image", "Video"}},
+	)
+	tags := core.NewBaseCollection("tags")
+	tags.Fields.Add(&core.TextField{Name: "title"})
+	for _, collection := range []*core.Collection{uploads, tags} {
+		if err := app.Save(collection); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	posts := core.NewBaseCollection("posts")
+	posts.Fields.Add(
+		&core.TextField{Name: "title"},
+		&core.TextField{Name: "summary"},
+		&core.TextField{Name: "content"},
+		&core.RelationField{Name: "featured_image", CollectionId: uploads.Id, MaxSelect: 1},
+		&core.RelationField{Name: "featured_images", CollectionId: uploads.Id, MaxSelect: 999},
+		&core.RelationField{Name: "tags", CollectionId: tags.Id, MaxSelect: 99},
+	)
+	if err := app.Save(posts); err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+func testUpload(t *testing.T, app core.App, imageURL, uploadType string) *core.Record {
+	t.Helper()
+
+	collection, err := app.FindCollectionByNameOrId("uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := core.NewRecord(collection)
+	upload.Set("url", imageURL)
+	upload.Set("type", uploadType)
+	if err := app.Save(upload); err != nil {
+		t.Fatal(err)
+	}
+	return upload
+}
+
+func testInstagramRecords(t *testing.T, app core.App, featured string, uploads []*core.Record) (post, account *core.Record) {
+	t.Helper()
+
+	posts, err := app.FindCollectionByNameOrId("posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content strings.Builder
+	for _, upload := range uploads {
+		fmt.Fprintf(&content, "![](/api/files/uploads/%s/image)\n", upload.Id)
+	}
+	post = core.NewRecord(posts)
+	post.Set("title", "A post")
+	post.Set("summary", "A summary")
+	post.Set("featured_image", featured)
+	post.Set("content", content.String())
+
+	account = core.NewRecord(core.NewBaseCollection("instagram_accounts"))
+	account.Set("access_key", "instagram-token")
+
+	return post, account
+}
+
+func TestInstagramCreateCarousel(t *testing.T) {
+	app := newTestApp(t)
+	stub := newInstagramStub(t)
+
+	var uploads []*core.Record
+	for i := range instagramCarouselLimit + 2 {
+		uploads = append(uploads, testUpload(t, app, fmt.Sprintf("https://cdn.example.com/%d.JPG", i), "Image"))
+	}
+	// a png, and a jpeg that isn't an image upload, so never in the carousel
+	uploads = append([]*core.Record{
+		testUpload(t, app, "https://cdn.example.com/diagram.png", "Image"),
+		testUpload(t, app, "https://cdn.example.com/clip.jpg", "Video"),
+	}, uploads...)
+
+	post, account := testInstagramRecords(t, app, "", uploads)
+
+	remoteID, remoteURL, err := newInstagramPublisher(app, stub.URL+"/v21.0", "https://example.com").Create(context.Background(), post, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteID != "m99" || remoteURL != "https://www.instagram.com/p/m99/" {
+		t.Errorf("got %q %q, want the published media", remoteID, remoteURL)
+	}
+
+	// the children, then the carousel itself
+	if len(stub.containers) != instagramCarouselLimit+1 {
+		t.Fatalf("got %d containers, want %d", len(stub.containers), instagramCarouselLimit+1)
+	}
+	for i, child := range stub.containers[:instagramCarouselLimit] {
+		want := fmt.Sprintf("https://cdn.example.com/%d.JPG", i)
+		if child["image_url"] != want || child["is_carousel_item"] != "true" {
+			t.Errorf("got child %v, want %s", child, want)
+		}
+	}
+
+	carousel := stub.containers[instagramCarouselLimit]
+	if carousel["media_type"] != "CAROUSEL" || len(strings.Split(carousel["children"], ",")) != instagramCarouselLimit {
+		t.Errorf("got carousel %v, want %d children", carousel, instagramCarouselLimit)
+	}
+	if carousel["caption"] != "A summary" {
+		t.Errorf("got caption %q, want the summary", carousel["caption"])
+	}
+	if stub.published != 1 {
+		t.Errorf("got %d publishes, want 1", stub.published)
+	}
+}
+
+func TestInstagramCreateFeaturedImages(t *testing.T) {
+	app := newTestApp(t)
+	stub := newInstagramStub(t)
+
+	featured := testUpload(t, app, "https://cdn.example.com/featured.jpg", "Image")
+	first := testUpload(t, app, "https://cdn.example.com/first.jpg", "Image")
+	// featured images are used whatever their upload type
+	second := testUpload(t, app, "https://cdn.example.com/second.jpg", "")
+	png := testUpload(t, app, "https://cdn.example.com/diagram.png", "Image")
+	inline := testUpload(t, app, "https://cdn.example.com/inline.jpg", "Image")
+
+	// first is used inline too, but only shows up once
+	post, account := testInstagramRecords(t, app, featured.Id, []*core.Record{inline, first})
+	post.Set("featured_images", []string{first.Id, png.Id, second.Id})
+
+	if _, _, err := newInstagramPublisher(app, stub.URL+"/v21.0", "https://example.com").Create(context.Background(), post, account); err != nil {
+		t.Fatal(err)
+	}
+
+	var images []string
+	for _, container := range stub.containers {
+		if container["is_carousel_item"] == "true" {
+			images = append(images, container["image_url"])
+		}
+	}
+	want := []string{
+		"https://cdn.example.com/featured.jpg",
+		"https://cdn.example.com/first.jpg",
+		"https://cdn.example.com/second.jpg",
+		"https://cdn.example.com/inline.jpg",
+	}
+	if strings.Join(images, " ") != strings.Join(want, " ") {
+		t.Errorf("got carousel %v, want %v", images, want)
+	}
+}
+
+func TestInstagramCreateSingleImage(t *testing.T) {
+	app := newTestApp(t)
+	stub := newInstagramStub(t)
+
+	// the featured image is a stored file, linked under the site url
+	collection, err := app.FindCollectionByNameOrId("uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := filesystem.NewFileFromBytes([]byte("jpeg"), "cover.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	featured := core.NewRecord(collection)
+	featured.Set("file", file)
+	if err := app.Save(featured); err != nil {
+		t.Fatal(err)
+	}
+	png := testUpload(t, app, "https://cdn.example.com/diagram.png", "Image")
+
+	post, account := testInstagramRecords(t, app, featured.Id, []*core.Record{png})
+
+	if _, _, err := newInstagramPublisher(app, stub.URL+"/v21.0", "https://example.com").Create(context.Background(), post, account); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stub.containers) != 1 {
+		t.Fatalf("got %d containers, want 1", len(stub.containers))
+	}
+	image := stub.containers[0]
+	want := fmt.Sprintf("https://example.com/api/files/uploads/%s/%s", featured.Id, featured.GetString("file"))
+	if image["image_url"] != want || image["media_type"] != "" || image["is_carousel_item"] != "" {
+		t.Errorf("got %v, want a single image at %s", image, want)
+	}
+}
+
+func TestInstagramCreateWithoutJPEGs(t *testing.T) {
+	app := newTestApp(t)
+	stub := newInstagramStub(t)
+
+	uploads := []*core.Record{
+		testUpload(t, app, "https://cdn.example.com/diagram.png", "Image"),
+		testUpload(t, app, "https://cdn.example.com/photo.webp", "Image"),
+	}
+	post, account := testInstagramRecords(t, app, "", uploads)
+
+	_, _, err := newInstagramPublisher(app, stub.URL+"/v21.0", "https://example.com").Create(context.Background(), post, account)
+
+	var permanentErr *permanentError
+	if !errors.As(err, &permanentErr) {
+		t.Errorf("got %v, want a permanent error", err)
+	}
+	if len(stub.containers) != 0 {
+		t.Errorf("got %d containers, want none", len(stub.containers))
+	}
+}
+
+func TestPublicSiteURL(t *testing.T) {
+	tests := []struct {
+		appURL string
+		want   string
+	}{
+		{"https://example.com/", "https://example.com"},
+		{"http://localhost:8090", "http://localhost:8090"},
+		{"", ""},
+		{"example.com", ""},
+		{"/feed", ""},
+		{"ftp://example.com", ""},
+	}
+
+	for _, test := range tests {
+		got, err := publicSiteURL(test.appURL)
+		if got != test.want || (err == nil) != (test.want != "") {
+			t.Errorf("publicSiteURL(%q) = %q, %v, want %q", test.appURL, got, err, test.want)
+		}
+	}
+}
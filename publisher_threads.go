@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	threadsGraphURL = "https://graph.threads.net/v1.0"
+	// threads rejects text posts longer than this many characters
+	threadsTextLimit = 500
+)
+
+// threadsPublisher posts to threads as text posts, using the threads token
+// of an instagram account linked to threads. threads tokens are separate
+// graph tokens from the instagram access_key. posts link back under siteURL
+type threadsPublisher struct {
+	graph   *graphClient
+	siteURL string
+}
+
+func newThreadsPublisher(baseURL, siteURL string) *threadsPublisher {
+	if baseURL == "" {
+		baseURL = threadsGraphURL
+	}
+	return &threadsPublisher{graph: newGraphClient(baseURL), siteURL: siteURL}
+}
+
+// Create publishes a text container for the post, then looks up its
+// permalink
+func (p *threadsPublisher) Create(ctx context.Context, post, account *core.Record) (string, string, error) {
+	token, err := threadsToken(account)
+	if err != nil {
+		return "", "", err
+	}
+
+	var container struct {
+		ID string `json:"id"`
+	}
+	err = p.graph.do(ctx, http.MethodPost, "me/threads", token, url.Values{
+		"media_type": {"TEXT"},
+		"text":       {threadsText(p.siteURL, post)},
+	}, &container)
+	if err != nil {
+		return "", "", err
+	}
+
+	var published struct {
+		ID string `json:"id"`
+	}
+	err = p.graph.do(ctx, http.MethodPost, "me/threads_publish", token, url.Values{
+		"creation_id": {container.ID},
+	}, &published)
+	if err != nil {
+		return "", "", err
+	}
+
+	var media struct {
+		Permalink string `json:"permalink"`
+	}
+	err = p.graph.do(ctx, http.MethodGet, published.ID, token, url.Values{
+		"fields": {"permalink"},
+	}, &media)
+	if err != nil {
+		return "", "", err
+	}
+
+	return published.ID, media.Permalink, nil
+}
+
+// Update leaves the post as it is, threads posts can't be edited
+func (p *threadsPublisher) Update(ctx context.Context, post, account *core.Record, remoteID string) (string, string, error) {
+	return remoteID, "", nil
+}
+
+func (p *threadsPublisher) Delete(ctx context.Context, post, account *core.Record, remoteID string) (string, string, error) {
+	token, err := threadsToken(account)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := p.graph.do(ctx, http.MethodDelete, remoteID, token, nil, nil); err != nil {
+		return "", "", err
+	}
+	return remoteID, "", nil
+}
+
+func threadsToken(account *core.Record) (string, error) {
+	if account == nil || !account.GetBool("has_threads_link") {
+		return "", permanent(errors.New("the account isn't linked to threads"))
+	}
+	token := account.GetString("threads_access_key")
+	if token == "" {
+		return "", permanent(errors.New("the account has no threads access key"))
+	}
+	return token, nil
+}
+
+// threadsText is the title, summary and permalink of a post. the summary is
+// cut to fit the character limit, then the title if it's still too long
+func threadsText(siteURL string, post *core.Record) string {
+	title := strings.TrimSpace(post.GetString("title"))
+	summary := strings.TrimSpace(post.GetString("summary"))
+	link := postURL(siteURL, post)
+
+	// the blank lines between the parts
+	room := threadsTextLimit - utf8.RuneCountInString(link) - 2
+	title = truncateRunes(title, room)
+	room -= utf8.RuneCountInString(title)
+
+	parts := []string{title}
+	if summary != "" && room > 2+1 {
+		parts = append(parts, truncateRunes(summary, room-2))
+	}
+	parts = append(parts, link)
+
+	return strings.Join(parts, "\n\n")
+}
+
+// truncateRunes cuts s to at most limit characters, ending it with an
+// ellipsis when it had to be cut
+func truncateRunes(s string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
+
+func linkedThreadsAccount(txApp core.App) (*core.Record, error) {
+	accounts, err := txApp.FindRecordsByFilter("instagram_accounts", "has_threads_link = true && threads_access_key != ''", "-created", 1, 0)
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+	return accounts[0], nil
+}
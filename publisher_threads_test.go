@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// threadsStub stands in for the threads graph api. the publish step answers
+// with publishStatus when it's set
+func threadsStub(t *testing.T, publishStatus int) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != "threads-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"Invalid OAuth access token","type":"OAuthException","code":190}}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1.0/me/threads":
+			texts = append(texts, r.URL.Query().Get("text"))
+			w.Write([]byte(`{"id":"c1"}`))
+		case "/v1.0/me/threads_publish":
+			if publishStatus != 0 {
+				w.WriteHeader(publishStatus)
+				w.Write([]byte(`{"error":{"message":"stub error","type":"OAuthException","code":1}}`))
+				return
+			}
+			w.Write([]byte(`{"id":"t42"}`))
+		case "/v1.0/t42":
+			w.Write([]byte(`{"permalink":"https://www.threads.net/@feed/post/t42"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &texts
+}
+
+func testThreadsRecords(title, summary string) (post, account *core.Record) {
+	post = core.NewRecord(core.NewBaseCollection("posts"))
+	post.Set("title", title)
+	post.Set("summary", summary)
+	post.Set("slug", "a-post")
+
+	account = core.NewRecord(core.NewBaseCollection("instagram_accounts"))
+	account.Set("access_key", "instagram-token")
+	account.Set("threads_access_key", "threads-token")
+	account.Set("has_threads_link", true)
+
+	return post, account
+}
+
+func TestThreadsCreate(t *testing.T) {
+	server, texts := threadsStub(t, 0)
+	post, account := testThreadsRecords("A post", strings.Repeat("ü", 1000))
+
+	remoteID, remoteURL, err := newThreadsPublisher(server.URL+"/v1.0", "https://example.com").Create(context.Background(), post, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteID != "t42" || remoteURL != "https://www.threads.net/@feed/post/t42" {
+		t.Errorf("got %q %q, want the published thread", remoteID, remoteURL)
+	}
+
+	if len(*texts) != 1 {
+		t.Fatalf("got %d containers, want 1", len(*texts))
+	}
+	text := (*texts)[0]
+	if count := utf8.RuneCountInString(text); count > threadsTextLimit {
+		t.Errorf("got %d characters, want at most %d", count, threadsTextLimit)
+	}
+	if !strings.HasPrefix(text, "A post\n\n") || !strings.HasSuffix(text, "…\n\nhttps://example.com/posts/a-post") {
+		t.Errorf("got %q, want the title, cut summary and link", text)
+	}
+}
+
+func TestThreadsTextLongTitle(t *testing.T) {
+	post, _ := testThreadsRecords(strings.Repeat("a", 600), "a summary")
+
+	text := threadsText("https://example.com", post)
+	if count := utf8.RuneCountInString(text); count != threadsTextLimit {
+		t.Errorf("got %d characters, want %d", count, threadsTextLimit)
+	}
+	if strings.Contains(text, "a summary") {
+		t.Errorf("got %q, want the summary left out", text)
+	}
+}
+
+func TestThreadsCreateErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		token     string
+		permanent bool
+	}{
+		{"bad request", http.StatusBadRequest, "threads-token", true},
+		{"invalid token", 0, "expired-token", true},
+		{"server error", http.StatusInternalServerError, "threads-token", false},
+		{"rate limited", http.StatusTooManyRequests, "threads-token", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := threadsStub(t, test.status)
+			post, account := testThreadsRecords("A post", "")
+			account.Set("threads_access_key", test.token)
+
+			_, _, err := newThreadsPublisher(server.URL+"/v1.0", "https://example.com").Create(context.Background(), post, account)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			var permanentErr *permanentError
+			if errors.As(err, &permanentErr) != test.permanent {
+				t.Errorf("got %v, want permanent to be %v", err, test.permanent)
+			}
+		})
+	}
+}
+
+func TestThreadsCreateWithoutThreadsToken(t *testing.T) {
+	server, texts := threadsStub(t, 0)
+	post, account := testThreadsRecords("A post", "")
+	account.Set("threads_access_key", "")
+
+	_, _, err := newThreadsPublisher(server.URL+"/v1.0", "https://example.com").Create(context.Background(), post, account)
+
+	var permanentErr *permanentError
+	if !errors.As(err, &permanentErr) {
+		t.Errorf("got %v, want a permanent error", err)
+	}
+	if len(*texts) != 0 {
+		t.Errorf("got %d containers, want none", len(*texts))
+	}
+}
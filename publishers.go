@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Publisher crossposts posts to one platform. account is the
+// instagram_accounts record the job was queued for and remoteID the id the
+// post got from an earlier Create
+type Publisher interface {
+	Create(ctx context.Context, post, account *core.Record) (remoteID, remoteURL string, err error)
+	Update(ctx context.Context, post, account *core.Record, remoteID string) (string, string, error)
+	Delete(ctx context.Context, post, account *core.Record, remoteID string) (string, string, error)
+}
+
+// crosspostPlatform is a platform posts can be crossposted to
+type crosspostPlatform struct {
+	// Flag is the frontmatter key asking for the platform, Enabled reads it
+	Flag    string
+	Enabled func(frontmatter *PostFrontmatter) bool
+	// Account picks the account jobs are queued against, nil when there is
+	// none. MissingAccount is the warning given then
+	Account        func(txApp core.App) (*core.Record, error)
+	MissingAccount string
+	// URLField is the instagram_posts field the post's url is kept in
+	URLField string
+	// CanDelete is whether Delete can take a post down again
+	CanDelete bool
+	// NeedsReauth and TokenError are the instagram_accounts fields that
+	// pause the platform's jobs once its token is rejected
+	NeedsReauth string
+	TokenError  string
+	// Publisher runs the jobs. until there is one they wait in the queue
+	Publisher Publisher
+}
+
+// crosspostPlatforms is the publisher registry, keyed by the platform name
+// used in crosspost_queue
+var crosspostPlatforms = map[string]*crosspostPlatform{
+	"Instagram": {
+		Flag:           "crosspost_instagram",
+		Enabled:        func(frontmatter *PostFrontmatter) bool { return frontmatter.CrosspostInstagram },
+		Account:        latestInstagramAccount,
+		MissingAccount: "no instagram account is connected, the post isn't crossposted to instagram",
+		URLField:       "instagram_url",
+		// the instagram graph api has no way to delete media
+		CanDelete:   false,
+		NeedsReauth: "needs_reauth",
+		TokenError:  "token_error",
+	},
+	"Threads": {
+		Flag:           "crosspost_threads",
+		Enabled:        func(frontmatter *PostFrontmatter) bool { return frontmatter.CrosspostThreads },
+		Account:        linkedThreadsAccount,
+		MissingAccount: "no instagram account is linked to threads with a threads access key, the post isn't crossposted to threads",
+		URLField:       "threads_url",
+		CanDelete:      true,
+		NeedsReauth:    "threads_needs_reauth",
+		TokenError:     "threads_token_error",
+	},
+}
+
+// setupPublishers registers the publishers. the graph api urls can be
+// pointed at a local stand-in for testing. crossposts link to the site and
+// its images by APP_URL, so without an absolute one no publisher is
+// registered and jobs wait in the queue until it's set
+func (app *App) setupPublishers() {
+	siteURL, err := publicSiteURL(os.Getenv("APP_URL"))
+	if err != nil {
+		log.Printf("Crossposting is paused: %v", err)
+		return
+	}
+
+	registerPublisher("Instagram", newInstagramPublisher(app.pb, os.Getenv("INSTAGRAM_GRAPH_URL"), siteURL))
+	registerPublisher("Threads", newThreadsPublisher(os.Getenv("THREADS_GRAPH_URL"), siteURL))
+}
+
+// publicSiteURL checks that appURL is an absolute http(s) url, and returns
+// it without a trailing slash
+func publicSiteURL(appURL string) (string, error) {
+	if appURL == "" {
+		return "", errors.New("APP_URL isn't set")
+	}
+	parsed, err := url.Parse(appURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("APP_URL %q isn't an absolute http(s) url", appURL)
+	}
+	return strings.TrimRight(appURL, "/"), nil
+}
+
+// registerPublisher sets the publisher for a platform in the registry
+func registerPublisher(name string, publisher Publisher) {
+	platform, ok := crosspostPlatforms[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown crosspost platform %q", name))
+	}
+	platform.Publisher = publisher
+}
+
+// crosspostPlatformNames lists the registry in a stable order
+func crosspostPlatformNames() []string {
+	names := make([]string, 0, len(crosspostPlatforms))
+	for name := range crosspostPlatforms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func latestInstagramAccount(txApp core.App) (*core.Record, error) {
+	accounts, err := txApp.FindRecordsByFilter("instagram_accounts", "", "-created", 1, 0)
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+	return accounts[0], nil
+}
+
+// publishCrosspost runs a job with its platform's publisher. a post is only
+// created once: creating it again is a no-op, as is an update while its
+// create is still pending. updates of a post that never made it to the
+// platform create it instead, and deleting it is a no-op
+func (app *App) publishCrosspost(ctx context.Context, publisher Publisher, job, post *core.Record) (string, string, error) {
+	var account *core.Record
+	if accountID := job.GetString("instagram_account"); accountID != "" {
+		var err error
+		account, err = app.pb.FindRecordById("instagram_accounts", accountID)
+		if err != nil {
+			return "", "", permanent(fmt.Errorf("account not found: %w", err))
+		}
+	}
+
+	remoteID, err := app.crosspostRemoteID(post.Id, job.GetString("platform"))
+	if err != nil {
+		return "", "", err
+	}
+
+	switch job.GetString("type") {
+	case "Create":
+		if remoteID != "" {
+			return remoteID, "", nil
+		}
+		return publisher.Create(ctx, post, account)
+	case "Update":
+		if remoteID == "" {
+			creating, err := app.hasPendingCreate(job)
+			if err != nil || creating {
+				return "", "", err
+			}
+			return publisher.Create(ctx, post, account)
+		}
+		return publisher.Update(ctx, post, account, remoteID)
+	case "Delete":
+		if remoteID == "" {
+			return "", "", nil
+		}
+		return publisher.Delete(ctx, post, account, remoteID)
+	}
+	return "", "", permanent(fmt.Errorf("unknown job type %q", job.GetString("type")))
+}
+
+// crosspostRemoteID is the id a post has on a platform, from its last
+// successful job there. it's empty once that job deleted the post
+func (app *App) crosspostRemoteID(postID, platform string) (string, error) {
+	jobs, err := app.pb.FindRecordsByFilter(
+		"crosspost_queue",
+		"post = {:postId} && platform = {:platform} && status = 'Success' && remote_id != ''",
+		"-completed",
+		1,
+		0,
+		dbx.Params{"postId": postID, "platform": platform},
+	)
+	if err != nil || len(jobs) == 0 || jobs[0].GetString("type") == "Delete" {
+		return "", err
+	}
+	return jobs[0].GetString("remote_id"), nil
+}
+
+// hasPendingCreate reports whether the job's post has a create job on the
+// same platform that hasn't finished yet
+func (app *App) hasPendingCreate(job *core.Record) (bool, error) {
+	count, err := app.pb.CountRecords(
+		"crosspost_queue",
+		dbx.HashExp{"post": job.GetString("post"), "platform": job.GetString("platform"), "type": "Create"},
+		dbx.In("status", "Queued", "Scheduled", "Processing", "Failure"),
+		dbx.Not(dbx.HashExp{"id": job.Id}),
+	)
+	return count > 0, err
+}
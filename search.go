@@ -0,0 +1,230 @@
+package main
+
+import (
+	"feed/views"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const searchResultsLimit = 20
+
+var searchTermRegex = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// snippet() wraps matches in these so the text can be escaped before the
+// <mark> tags go in
+const (
+	snippetMatchStart = "\x02"
+	snippetMatchEnd   = "\x03"
+)
+
+type searchHit struct {
+	PostID  string `db:"post_id"`
+	Snippet string `db:"snippet"`
+}
+
+func (app *App) searchPage(re *core.RequestEvent) error {
+	query := strings.TrimSpace(re.Request.URL.Query().Get("q"))
+
+	posts, err := app.searchPosts(query)
+	if err != nil {
+		app.pb.Logger().Error("Error searching posts", "query", query, "error", err)
+		return re.InternalServerError("Failed to search posts", err)
+	}
+
+	// only full page loads count, which is a submitted search or a shared
+	// link. the htmx partial runs on every pause while typing
+	if buildMatchQuery(query) != "" {
+		app.countTagSearches(query)
+	}
+
+	component := views.SearchPage(query, posts)
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// searchResultsPartial renders just the results list for htmx, as the
+// query is typed
+func (app *App) searchResultsPartial(re *core.RequestEvent) error {
+	query := strings.TrimSpace(re.Request.URL.Query().Get("q"))
+
+	posts, err := app.searchPosts(query)
+	if err != nil {
+		app.pb.Logger().Error("Error searching posts", "query", query, "error", err)
+		return re.InternalServerError("Failed to search posts", err)
+	}
+
+	component := views.SearchResults(query, posts)
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// searchPosts returns visible posts matching the query, best match first,
+// each with a highlighted search_snippet
+func (app *App) searchPosts(query string) ([]*core.Record, error) {
+	match := buildMatchQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	// column weights follow the fts column order:
+	// post_id, title, subtitle, summary, content, tags
+	var hits []searchHit
+	err := app.pb.DB().NewQuery(`
+		SELECT
+			posts_fts.post_id AS post_id,
+			snippet(posts_fts, -1, {:start}, {:end}, '…', 24) AS snippet
+		FROM posts_fts
+		JOIN posts ON posts.id = posts_fts.post_id
+		WHERE posts_fts MATCH {:match} AND ` + visibleSQL("posts") + `
+		ORDER BY bm25(posts_fts, 0.0, 10.0, 5.0, 3.0, 1.0, 4.0)
+		LIMIT {:limit}
+	`).Bind(dbx.Params{
+		"start": snippetMatchStart,
+		"end":   snippetMatchEnd,
+		"match": match,
+		"limit": searchResultsLimit,
+	}).All(&hits)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hits) == 0 {
+		return nil, nil
+	}
+
+	postIDs := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		postIDs = append(postIDs, hit.PostID)
+	}
+
+	found, err := app.pb.FindRecordsByIds("posts", postIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// FindRecordsByIds doesn't keep the ranking order
+	byID := make(map[string]*core.Record, len(found))
+	for _, post := range found {
+		byID[post.Id] = post
+	}
+
+	posts := make([]*core.Record, 0, len(hits))
+	for _, hit := range hits {
+		if post, ok := byID[hit.PostID]; ok {
+			post.Set("search_snippet", highlightSnippet(hit.Snippet))
+			posts = append(posts, post)
+		}
+	}
+
+	app.expandFeedPosts(posts)
+	return posts, nil
+}
+
+// buildMatchQuery turns free text into an fts5 query where every word has to
+// match as a prefix. anything that isn't a letter or number is dropped so
+// user input can't produce fts syntax errors
+func buildMatchQuery(query string) string {
+	terms := searchTermRegex.FindAllString(query, -1)
+
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, fmt.Sprintf(`"%s"*`, term))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetMatchStart, "<mark>")
+	return strings.ReplaceAll(escaped, snippetMatchEnd, "</mark>")
+}
+
+// countTagSearches bumps search_count on tags named by the query, either as
+// the whole query or one of its words
+func (app *App) countTagSearches(query string) {
+	terms := append([]string{query}, searchTermRegex.FindAllString(query, -1)...)
+
+	params := dbx.Params{}
+	placeholders := make([]string, 0, len(terms))
+	for i, term := range terms {
+		key := fmt.Sprintf("term%d", i)
+		params[key] = strings.ToLower(term)
+		placeholders = append(placeholders, "{:"+key+"}")
+	}
+
+	_, err := app.pb.DB().Update(
+		"tags",
+		dbx.Params{"search_count": dbx.NewExp("[[search_count]] + 1")},
+		dbx.NewExp("LOWER([[title]]) IN ("+strings.Join(placeholders, ", ")+")", params),
+	).Execute()
+	if err != nil {
+		app.pb.Logger().Error("Failed to count tag searches", "query", query, "error", err)
+	}
+}
+
+// indexPost replaces the post's row in the search index
+func (app *App) indexPost(post *core.Record) error {
+	var tagTitles []string
+	if tagIDs := post.GetStringSlice("tags"); len(tagIDs) > 0 {
+		tags, err := app.pb.FindRecordsByIds("tags", tagIDs)
+		if err != nil {
+			return fmt.Errorf("failed to load tags: %v", err)
+		}
+		for _, tag := range tags {
+			tagTitles = append(tagTitles, tag.GetString("title"))
+		}
+	}
+
+	if err := app.unindexPost(post.Id); err != nil {
+		return err
+	}
+
+	_, err := app.pb.DB().Insert("posts_fts", dbx.Params{
+		"post_id":  post.Id,
+		"title":    post.GetString("title"),
+		"subtitle": post.GetString("subtitle"),
+		"summary":  post.GetString("summary"),
+		"content":  post.GetString("content"),
+		"tags":     strings.Join(tagTitles, " "),
+	}).Execute()
+	return err
+}
+
+func (app *App) unindexPost(postID string) error {
+	_, err := app.pb.DB().Delete("posts_fts", dbx.HashExp{"post_id": postID}).Execute()
+	return err
+}
+
+func (app *App) indexPostHook(e *core.RecordEvent) error {
+	if err := app.indexPost(e.Record); err != nil {
+		app.pb.Logger().Error("Failed to index post", "post_id", e.Record.Id, "error", err)
+	}
+	return e.Next()
+}
+
+func (app *App) unindexPostHook(e *core.RecordEvent) error {
+	if err := app.unindexPost(e.Record.Id); err != nil {
+		app.pb.Logger().Error("Failed to unindex post", "post_id", e.Record.Id, "error", err)
+	}
+	return e.Next()
+}
+
+// reindexTagPostsHook refreshes posts using a tag after it's renamed
+func (app *App) reindexTagPostsHook(e *core.RecordEvent) error {
+	posts, err := app.pb.FindRecordsByFilter("posts", "tags ~ {:tagId}", "", 0, 0, map[string]any{"tagId": e.Record.Id})
+	if err != nil {
+		app.pb.Logger().Error("Failed to find posts for tag", "tag_id", e.Record.Id, "error", err)
+		return e.Next()
+	}
+
+	for _, post := range posts {
+		if err := app.indexPost(post); err != nil {
+			app.pb.Logger().Error("Failed to index post", "post_id", post.Id, "error", err)
+		}
+	}
+	return e.Next()
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+const syndicationLimit = 20
+
+const (
+	siteTitle       = "The Feed - krugg.dev"
+	siteDescription = "A collection of software, drawings and other useless nonsense."
+	siteAuthor      = "krugg.dev"
+)
+
+type feedFormat string
+
+const (
+	feedRSS  feedFormat = "rss"
+	feedAtom feedFormat = "atom"
+	feedJSON feedFormat = "json"
+)
+
+// syndicationFeed is everything needed to write a feed in any format
+type syndicationFeed struct {
+	Title       string
+	Description string
+	Link        string // html page the feed mirrors
+	SelfURL     string
+	Posts       []*core.Record
+}
+
+// feedEnclosure is the featured image attached to an entry
+type feedEnclosure struct {
+	URL  string
+	Type string
+	Size int64
+}
+
+// siteFeed serves the main feed of every visible post
+func (app *App) siteFeed(format feedFormat) func(*core.RequestEvent) error {
+	return func(re *core.RequestEvent) error {
+		posts, err := app.pb.FindRecordsByFilter(
+			"posts",
+			visibleFilter(""),
+			"-created",
+			syndicationLimit,
+			0,
+		)
+		if err != nil {
+			app.pb.Logger().Error("Error fetching posts for feed", "error", err)
+			return re.InternalServerError("Failed to load feed", err)
+		}
+
+		baseURL := app.siteURL(re)
+		return app.serveFeed(re, format, &syndicationFeed{
+			Title:       siteTitle,
+			Description: siteDescription,
+			Link:        baseURL + "/",
+			SelfURL:     baseURL + re.Request.URL.Path,
+			Posts:       posts,
+		})
+	}
+}
+
+// tagFeed serves visible posts carrying the tag
+func (app *App) tagFeed(format feedFormat) func(*core.RequestEvent) error {
+	return func(re *core.RequestEvent) error {
+		title := re.Request.PathValue("title")
+		tag, err := app.findTagByTitle(title)
+		if err != nil {
+			return re.NotFoundError("Tag not found", err)
+		}
+
+		posts, err := app.pb.FindRecordsByFilter(
+			"posts",
+			visibleFilter("")+" && tags ~ {:tagId}",
+			"-created",
+			syndicationLimit,
+			0,
+			map[string]any{"tagId": tag.Id},
+		)
+		if err != nil {
+			app.pb.Logger().Error("Error fetching posts for tag feed", "tag", title, "error", err)
+			return re.InternalServerError("Failed to load feed", err)
+		}
+
+		baseURL := app.siteURL(re)
+		return app.serveFeed(re, format, &syndicationFeed{
+			Title:       fmt.Sprintf("#%s - %s", tag.GetString("title"), siteTitle),
+			Description: fmt.Sprintf("Posts tagged %s", tag.GetString("title")),
+			Link:        baseURL + "/tags/" + url.PathEscape(tag.GetString("title")),
+			SelfURL:     baseURL + re.Request.URL.EscapedPath(),
+			Posts:       posts,
+		})
+	}
+}
+
+// contextFeed serves visible posts linked to the context via context_posts
+func (app *App) contextFeed(format feedFormat) func(*core.RequestEvent) error {
+	return func(re *core.RequestEvent) error {
+		title := re.Request.PathValue("title")
+		context, err := app.findContextByTitle(title)
+		if err != nil {
+			return re.NotFoundError("Context not found", err)
+		}
+
+		contextPosts, err := app.pb.FindRecordsByFilter(
+			"context_posts",
+			"context = {:contextId} && "+visibleFilter("post."),
+			"-post.created",
+			syndicationLimit,
+			0,
+			map[string]any{"contextId": context.Id},
+		)
+		if err != nil {
+			app.pb.Logger().Error("Error fetching posts for context feed", "context", title, "error", err)
+			return re.InternalServerError("Failed to load feed", err)
+		}
+
+		posts, err := app.junctionPosts(contextPosts)
+		if err != nil {
+			return re.InternalServerError("Failed to load feed", err)
+		}
+
+		description := context.GetString("description")
+		if description == "" {
+			description = fmt.Sprintf("Posts in %s", context.GetString("title"))
+		}
+
+		baseURL := app.siteURL(re)
+		return app.serveFeed(re, format, &syndicationFeed{
+			Title:       fmt.Sprintf("%s - %s", context.GetString("title"), siteTitle),
+			Description: description,
+			Link:        baseURL + "/contexts/" + url.PathEscape(context.GetString("title")),
+			SelfURL:     baseURL + re.Request.URL.EscapedPath(),
+			Posts:       posts,
+		})
+	}
+}
+
+// collectionFeed serves a collection's visible posts in series order
+func (app *App) collectionFeed(format feedFormat) func(*core.RequestEvent) error {
+	return func(re *core.RequestEvent) error {
+		slug := re.Request.PathValue("slug")
+		collection, err := app.pb.FindFirstRecordByFilter("collections", "slug = {:slug}", map[string]any{"slug": slug})
+		if err != nil {
+			return re.NotFoundError("Collection not found", err)
+		}
+
+		posts, err := app.findCollectionPosts(collection.Id)
+		if err != nil {
+			app.pb.Logger().Error("Error fetching posts for collection feed", "collection", slug, "error", err)
+			return re.InternalServerError("Failed to load feed", err)
+		}
+
+		description := collection.GetString("description")
+		if description == "" {
+			description = fmt.Sprintf("The %s series", collection.GetString("title"))
+		}
+
+		baseURL := app.siteURL(re)
+		return app.serveFeed(re, format, &syndicationFeed{
+			Title:       fmt.Sprintf("%s - %s", collection.GetString("title"), siteTitle),
+			Description: description,
+			Link:        baseURL + "/collections/" + url.PathEscape(collection.GetString("slug")),
+			SelfURL:     baseURL + re.Request.URL.EscapedPath(),
+			Posts:       posts,
+		})
+	}
+}
+
+// junctionPosts expands the post relation of context_posts / collection_posts
+// rows, keeping the rows' order
+func (app *App) junctionPosts(records []*core.Record) ([]*core.Record, error) {
+	errs := app.pb.ExpandRecords(records, []string{"post"}, nil)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to expand posts: %v", errs)
+	}
+
+	posts := make([]*core.Record, 0, len(records))
+	for _, record := range records {
+		if post := record.ExpandedOne("post"); post != nil {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+// serveFeed renders the feed and answers conditional requests with a 304
+// when neither the etag nor the newest post changed
+func (app *App) serveFeed(re *core.RequestEvent, format feedFormat, feed *syndicationFeed) error {
+	errs := app.pb.ExpandRecords(feed.Posts, []string{"tags", "featured_image"}, nil)
+	if len(errs) > 0 {
+		app.pb.Logger().Error("Failed to expand feed relations", "errors", errs)
+	}
+
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+	switch format {
+	case feedAtom:
+		body, err = app.renderAtom(re, feed)
+		contentType = "application/atom+xml; charset=utf-8"
+	case feedJSON:
+		body, err = app.renderJSONFeed(re, feed)
+		contentType = "application/feed+json; charset=utf-8"
+	default:
+		body, err = app.renderRSS(re, feed)
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+	if err != nil {
+		app.pb.Logger().Error("Failed to render feed", "format", format, "error", err)
+		return re.InternalServerError("Failed to render feed", err)
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	lastModified := feedUpdated(feed.Posts)
+
+	header := re.Response.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "public, max-age=300")
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(re.Request, etag, lastModified) {
+		re.Response.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return re.Blob(http.StatusOK, contentType, body)
+}
+
+// notModified follows rfc 9110: If-None-Match wins over If-Modified-Since
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+// feedUpdated is the newest updated time across the posts
+func feedUpdated(posts []*core.Record) time.Time {
+	var latest time.Time
+	for _, post := range posts {
+		if updated := post.GetDateTime("updated").Time(); updated.After(latest) {
+			latest = updated
+		}
+	}
+	return latest
+}
+
+// siteURL is the public origin, preferring APP_URL over the request host
+func (app *App) siteURL(re *core.RequestEvent) string {
+	if appURL := os.Getenv("APP_URL"); appURL != "" {
+		return strings.TrimRight(appURL, "/")
+	}
+
+	scheme := "http"
+	if re.IsTLS() {
+		scheme = "https"
+	}
+	return scheme + "://" + re.Request.Host
+}
+
+// postURL returns the stored permalink, or builds one for older posts
+func postURL(baseURL string, post *core.Record) string {
+	if permalink := post.GetString("permalink"); permalink != "" {
+		return permalink
+	}
+	return baseURL + "/posts/" + post.GetString("slug")
+}
+
+// absoluteContent points root-relative links and images at baseURL, since
+// feed readers have no page to resolve them against
+func absoluteContent(baseURL, content string) string {
+	content = strings.ReplaceAll(content, `src="/`, `src="`+baseURL+"/")
+	return strings.ReplaceAll(content, `href="/`, `href="`+baseURL+"/")
+}
+
+func postTagTitles(post *core.Record) []string {
+	var titles []string
+	for _, tag := range post.ExpandedAll("tags") {
+		titles = append(titles, tag.GetString("title"))
+	}
+	return titles
+}
+
+func (app *App) postEnclosure(fsys *filesystem.System, baseURL string, post *core.Record) *feedEnclosure {
+	upload := post.ExpandedOne("featured_image")
+	if upload == nil || upload.GetString("file") == "" {
+		return nil
+	}
+
+	file := upload.GetString("file")
+	enclosure := &feedEnclosure{
+		URL:  fmt.Sprintf("%s/api/files/uploads/%s/%s", baseURL, upload.Id, file),
+		Type: mime.TypeByExtension(strings.ToLower(filepath.Ext(file))),
+	}
+	if enclosure.Type == "" {
+		enclosure.Type = "application/octet-stream"
+	}
+
+	if fsys != nil {
+		if attrs, err := fsys.Attributes(upload.BaseFilesPath() + "/" + file); err == nil {
+			enclosure.Size = attrs.Size
+		}
+	}
+
+	return enclosure
+}
+
+// feedEntry is the format independent view of a post
+type feedEntry struct {
+	ID          string
+	URL         string
+	Title       string
+	Summary     string
+	ContentHTML string
+	Tags        []string
+	Published   time.Time
+	Updated     time.Time
+	Enclosure   *feedEnclosure
+}
+
+func (app *App) feedEntries(re *core.RequestEvent, feed *syndicationFeed) []feedEntry {
+	baseURL := app.siteURL(re)
+
+	fsys, err := app.pb.NewFilesystem()
+	if err != nil {
+		app.pb.Logger().Error("Failed to open filesystem for feed enclosures", "error", err)
+	} else {
+		defer fsys.Close()
+	}
+
+	entries := make([]feedEntry, 0, len(feed.Posts))
+	for _, post := range feed.Posts {
+		url := postURL(baseURL, post)
+		entries = append(entries, feedEntry{
+			ID:          url,
+			URL:         url,
+			Title:       post.GetString("title"),
+			Summary:     post.GetString("summary"),
+			ContentHTML: absoluteContent(baseURL, post.GetString("content_html")),
+			Tags:        postTagTitles(post),
+			Published:   post.GetDateTime("created").Time(),
+			Updated:     post.GetDateTime("updated").Time(),
+			Enclosure:   app.postEnclosure(fsys, baseURL, post),
+		})
+	}
+	return entries
+}
+
+// RSS 2.0
+
+type rssDocument struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description,omitempty"`
+	Content     *rssCDATA     `xml:"content:encoded,omitempty"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssCDATA struct {
+	Value string `xml:",cdata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func (app *App) renderRSS(re *core.RequestEvent, feed *syndicationFeed) ([]byte, error) {
+	doc := rssDocument{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: rssChannel{
+			Title:       feed.Title,
+			Link:        feed.Link,
+			Description: feed.Description,
+			AtomLink:    rssAtomLink{Href: feed.SelfURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if updated := feedUpdated(feed.Posts); !updated.IsZero() {
+		doc.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, entry := range app.feedEntries(re, feed) {
+		item := rssItem{
+			Title:       entry.Title,
+			Link:        entry.URL,
+			GUID:        rssGUID{Value: entry.ID, IsPermaLink: true},
+			PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			Description: entry.Summary,
+			Categories:  entry.Tags,
+		}
+		if entry.ContentHTML != "" {
+			item.Content = &rssCDATA{Value: entry.ContentHTML}
+		}
+		if entry.Enclosure != nil {
+			item.Enclosure = &rssEnclosure{URL: entry.Enclosure.URL, Length: entry.Enclosure.Size, Type: entry.Enclosure.Type}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+
+	return marshalXML(doc)
+}
+
+// Atom
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   atomAuthor  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// atomAuthor is given once for the whole feed, atom requires an author and
+// entries without their own inherit it
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Summary    string         `xml:"summary,omitempty"`
+	Content    *atomContent   `xml:"content,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (app *App) renderAtom(re *core.RequestEvent, feed *syndicationFeed) ([]byte, error) {
+	// atom requires updated. an empty feed gets a fixed date so its etag
+	// stays the same between requests
+	updated := feedUpdated(feed.Posts)
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+
+	doc := atomFeed{
+		Title:    feed.Title,
+		Subtitle: feed.Description,
+		ID:       feed.SelfURL,
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Author:   atomAuthor{Name: siteAuthor},
+		Links: []atomLink{
+			{Href: feed.SelfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: feed.Link, Rel: "alternate", Type: "text/html"},
+		},
+	}
+
+	for _, entry := range app.feedEntries(re, feed) {
+		item := atomEntry{
+			Title:     entry.Title,
+			ID:        entry.ID,
+			Links:     []atomLink{{Href: entry.URL, Rel: "alternate", Type: "text/html"}},
+			Published: entry.Published.UTC().Format(time.RFC3339),
+			Updated:   entry.Updated.UTC().Format(time.RFC3339),
+			Summary:   entry.Summary,
+		}
+		if entry.ContentHTML != "" {
+			item.Content = &atomContent{Type: "html", Value: entry.ContentHTML}
+		}
+		for _, tag := range entry.Tags {
+			item.Categories = append(item.Categories, atomCategory{Term: tag})
+		}
+		if entry.Enclosure != nil {
+			item.Links = append(item.Links, atomLink{
+				Href:   entry.Enclosure.URL,
+				Rel:    "enclosure",
+				Type:   entry.Enclosure.Type,
+				Length: entry.Enclosure.Size,
+			})
+		}
+		doc.Entries = append(doc.Entries, item)
+	}
+
+	return marshalXML(doc)
+}
+
+// JSON Feed 1.1
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Title         string               `json:"title"`
+	Summary       string               `json:"summary,omitempty"`
+	ContentHTML   string               `json:"content_html,omitempty"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published"`
+	DateModified  string               `json:"date_modified"`
+	Tags          []string             `json:"tags,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+func (app *App) renderJSONFeed(re *core.RequestEvent, feed *syndicationFeed) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.Link,
+		FeedURL:     feed.SelfURL,
+		Description: feed.Description,
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, entry := range app.feedEntries(re, feed) {
+		item := jsonFeedItem{
+			ID:            entry.ID,
+			URL:           entry.URL,
+			Title:         entry.Title,
+			Summary:       entry.Summary,
+			ContentHTML:   entry.ContentHTML,
+			DatePublished: entry.Published.UTC().Format(time.RFC3339),
+			DateModified:  entry.Updated.UTC().Format(time.RFC3339),
+			Tags:          entry.Tags,
+		}
+		if entry.Enclosure != nil {
+			item.Image = entry.Enclosure.URL
+			item.Attachments = []jsonFeedAttachment{{
+				URL:         entry.Enclosure.URL,
+				MimeType:    entry.Enclosure.Type,
+				SizeInBytes: entry.Enclosure.Size,
+			}}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func marshalXML(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
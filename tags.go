@@ -0,0 +1,121 @@
+package main
+
+import (
+	"feed/views"
+	"net/url"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type tagCount struct {
+	Tag   string `db:"tag"`
+	Count int    `db:"count"`
+}
+
+func (app *App) tagsPage(re *core.RequestEvent) error {
+	tags, err := app.pb.FindRecordsByFilter("tags", "", "title", 0, 0)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching tags", "error", err)
+		return re.InternalServerError("Failed to load tags", err)
+	}
+
+	if err := app.loadTagPostCounts(tags); err != nil {
+		app.pb.Logger().Error("Failed to count tag posts", "error", err)
+	}
+
+	component := views.TagsPage(tags)
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+func (app *App) tagSinglePage(re *core.RequestEvent) error {
+	tag, err := app.findTagByTitle(re.Request.PathValue("title"))
+	if err != nil {
+		return re.NotFoundError("Tag not found", err)
+	}
+
+	query := re.Request.URL.Query()
+	feedQuery := parseFeedQuery(query)
+	feedQuery.Tags = []string{tag.GetString("title")}
+
+	posts, cursor, err := app.findFeedPosts(feedQuery)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching tag posts", "tag_id", tag.Id, "error", err)
+		return re.InternalServerError("Failed to load posts", err)
+	}
+
+	basePath := "/tags/" + url.PathEscape(tag.GetString("title"))
+	component := views.TagSinglePage(tag, posts, feedPagination(basePath, basePath+"/posts", query, cursor))
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+// tagPostsPartial renders the next batch of a tag's posts for htmx
+func (app *App) tagPostsPartial(re *core.RequestEvent) error {
+	tag, err := app.findTagByTitle(re.Request.PathValue("title"))
+	if err != nil {
+		return re.NotFoundError("Tag not found", err)
+	}
+
+	query := re.Request.URL.Query()
+	feedQuery := parseFeedQuery(query)
+	feedQuery.Tags = []string{tag.GetString("title")}
+
+	posts, cursor, err := app.findFeedPosts(feedQuery)
+	if err != nil {
+		app.pb.Logger().Error("Error fetching tag posts", "tag_id", tag.Id, "error", err)
+		return re.InternalServerError("Failed to load posts", err)
+	}
+
+	basePath := "/tags/" + url.PathEscape(tag.GetString("title"))
+	component := views.PostsBatch(posts, feedPagination(basePath, basePath+"/posts", query, cursor))
+	return component.Render(re.Request.Context(), re.Response)
+}
+
+func (app *App) findTagByTitle(title string) (*core.Record, error) {
+	return app.pb.FindFirstRecordByFilter("tags", "title = {:title}", map[string]any{"title": title})
+}
+
+// findTagIDs looks up the ids of the tags with the given titles, skipping
+// titles that don't exist
+func (app *App) findTagIDs(titles []string) ([]string, error) {
+	values := make([]any, 0, len(titles))
+	for _, title := range titles {
+		values = append(values, title)
+	}
+
+	tags, err := app.pb.FindAllRecords("tags", dbx.In("title", values...))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		ids = append(ids, tag.Id)
+	}
+	return ids, nil
+}
+
+// loadTagPostCounts sets post_count on each tag to the number of visible
+// posts carrying it
+func (app *App) loadTagPostCounts(tags []*core.Record) error {
+	var counts []tagCount
+	err := app.pb.DB().NewQuery(`
+		SELECT j.value AS tag, COUNT(*) AS count
+		FROM posts p, json_each(CASE WHEN json_valid(p.tags) THEN p.tags ELSE '[]' END) j
+		WHERE ` + visibleSQL("p") + `
+		GROUP BY j.value
+	`).All(&counts)
+	if err != nil {
+		return err
+	}
+
+	byTag := make(map[string]int, len(counts))
+	for _, count := range counts {
+		byTag[count.Tag] = count.Count
+	}
+
+	for _, tag := range tags {
+		tag.Set("post_count", byTag[tag.Id])
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// createUpload stores a file for the cli, which can't use the uploads
+// collection api with an api token
+func (app *App) createUpload(re *core.RequestEvent) error {
+	files, err := re.FindUploadedFiles("file")
+	if err != nil || len(files) == 0 {
+		return re.BadRequestError("A file is required", err)
+	}
+
+	collection, err := app.pb.FindCollectionByNameOrId("uploads")
+	if err != nil {
+		return re.InternalServerError("Failed to load uploads", err)
+	}
+
+	upload := core.NewRecord(collection)
+	upload.Set("file", files[0])
+	upload.Set("description", re.Request.FormValue("description"))
+	upload.Set("type", re.Request.FormValue("type"))
+	upload.Set("url", re.Request.FormValue("url"))
+	upload.Set("credit_source_url", re.Request.FormValue("credit_source_url"))
+
+	if err := app.pb.Save(upload); err != nil {
+		return re.BadRequestError("Failed to save upload", err)
+	}
+
+	return re.JSON(http.StatusOK, upload)
+}